@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// discoverCmd lists the devices of the Shelly cloud account configured in the config file and
+// prints a config snippet for all supported devices, or with -diff the differences to the
+// devices in the config file.
+func discoverCmd(ctx context.Context, configFile string, args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	diff := fs.Bool("diff", false, "Print the differences to the devices in the config instead of a config snippet.")
+	fs.Parse(args)
+
+	// The config does not need to be valid (yet), e.g. it may not contain any devices.
+	cfg, err := config.Parse(configFile)
+	if err != nil {
+		return fmt.Errorf("unable to read config: %s", err)
+	}
+	if cfg.Server == "" || cfg.AuthKey == "" {
+		return errors.New("server and auth key need to be set in the config to discover devices")
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	infos, err := client.Devices(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list devices: %s", err)
+	}
+
+	if *diff {
+		printDiff(os.Stdout, cfg.Devices, infos)
+		return nil
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{"devices": supportedDevices(infos)}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode config snippet: %s", err)
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// supportedDevices returns the devices of the account with a supported device type as config
+// entries.
+func supportedDevices(infos []*shelly.DeviceInfo) []*config.Device {
+	devices := []*config.Device{}
+	for _, info := range infos {
+		devType, ok := shelly.DeviceTypeForModel(info.Model)
+		if !ok {
+			log.Printf("skipping device %q (ID %s) because its model %q is not supported\n", info.Name, info.ID, info.Model)
+			continue
+		}
+		devices = append(devices, &config.Device{
+			ID:   info.ID,
+			Name: info.Name,
+			Type: devType.Name(),
+		})
+	}
+	return devices
+}
+
+// printDiff writes the devices of the account which are new or have a different type compared to
+// the configured cloud devices as well as the configured devices which no longer exist.
+func printDiff(w io.Writer, configured []*config.Device, infos []*shelly.DeviceInfo) {
+	known := map[string]*config.Device{}
+	for _, dev := range configured {
+		if dev.Source != "" && dev.Source != config.SourceCloud {
+			continue
+		}
+		known[strings.ToLower(dev.ID)] = dev
+	}
+
+	changes := 0
+	for _, info := range infos {
+		devType := fmt.Sprintf("unsupported model %s", info.Model)
+		t, supported := shelly.DeviceTypeForModel(info.Model)
+		if supported {
+			devType = t.Name()
+		}
+		cfgDev, ok := known[strings.ToLower(info.ID)]
+		if !ok {
+			if supported {
+				fmt.Fprintf(w, "+ %s %q (%s)\n", info.ID, info.Name, devType)
+				changes++
+			}
+			continue
+		}
+		delete(known, strings.ToLower(info.ID))
+		if !strings.EqualFold(cfgDev.Type, devType) {
+			fmt.Fprintf(w, "~ %s %q (%s -> %s)\n", info.ID, info.Name, cfgDev.Type, devType)
+			changes++
+		}
+	}
+	for _, dev := range configured {
+		if _, ok := known[strings.ToLower(dev.ID)]; ok {
+			fmt.Fprintf(w, "- %s %q (%s)\n", dev.ID, dev.Name, dev.Type)
+			changes++
+		}
+	}
+
+	if changes == 0 {
+		log.Println("configured devices match the devices of the account")
+	}
+}
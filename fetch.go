@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+	"github.com/finfinack/shellyExport/pkg/tariff"
+)
+
+// fetcher pulls statistics from the configured sources with a bounded number of concurrent
+// requests across all devices, channels and chunks. The request rate to the Shelly cloud is
+// additionally capped by its client.
+type fetcher struct {
+	sources map[string]shelly.Source
+	tariffs map[string]*tariff.Tariff
+	cfg     *config.Config
+	workers chan struct{}
+}
+
+type result struct {
+	stats []*shelly.PowerConsumptionStatistics
+	err   error
+}
+
+type timeframe struct {
+	from time.Time
+	to   time.Time
+}
+
+func newFetcher(sources map[string]shelly.Source, tariffs map[string]*tariff.Tariff, cfg *config.Config) *fetcher {
+	return &fetcher{
+		sources: sources,
+		tariffs: tariffs,
+		cfg:     cfg,
+		workers: make(chan struct{}, cfg.Concurrency),
+	}
+}
+
+// chunkEnd returns the end of the timeframe starting at from which is fetched in one request.
+func chunkEnd(interval string, from time.Time) time.Time {
+	switch interval {
+	case config.IntervalHour:
+		return from.AddDate(0, 0, 1)
+	case config.IntervalMonth, config.IntervalYear:
+		// Align to calendar years so that no bucket spans two chunks.
+		return time.Date(from.Year()+1, 1, 1, 0, 0, 0, 0, from.Location())
+	default:
+		return from.AddDate(0, 1, 0)
+	}
+}
+
+// chunks splits the timeframe into the chunks which are fetched in one request each.
+func chunks(interval string, from, to time.Time) []timeframe {
+	frames := []timeframe{}
+	for {
+		end := chunkEnd(interval, from)
+		if !end.Before(to) {
+			end = to
+		}
+		frames = append(frames, timeframe{from: from, to: end})
+		if !end.Before(to) {
+			break
+		}
+		from = end
+	}
+	return frames
+}
+
+// pullAll starts pulling the statistics of all devices concurrently. The result for each device
+// is sent on the channel with the same index once all of its statistics have been pulled.
+func (f *fetcher) pullAll(ctx context.Context, devices []*config.Device) []<-chan *result {
+	results := []<-chan *result{}
+	for _, dev := range devices {
+		res := make(chan *result, 1)
+		go func() {
+			stats, err := f.pullStatistics(ctx, dev)
+			res <- &result{stats: stats, err: err}
+		}()
+		results = append(results, res)
+	}
+	return results
+}
+
+func (f *fetcher) pullStatistics(ctx context.Context, dev *config.Device) ([]*shelly.PowerConsumptionStatistics, error) {
+	stats := make([]*shelly.PowerConsumptionStatistics, len(dev.Channels))
+	errs := make([]error, len(dev.Channels))
+	var wg sync.WaitGroup
+	for i, ch := range dev.Channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats[i], errs[i] = f.pullChannelStatistics(ctx, dev, ch)
+		}()
+	}
+	wg.Wait()
+
+	if i := firstError(errs); i >= 0 {
+		return nil, fmt.Errorf("unable to pull statistics for channel %d: %s", dev.Channels[i].Index, errs[i])
+	}
+	return stats, nil
+}
+
+func (f *fetcher) pullChannelStatistics(ctx context.Context, dev *config.Device, ch *config.Channel) (*shelly.PowerConsumptionStatistics, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	source, ok := f.sources[dev.Source]
+	if !ok {
+		return nil, fmt.Errorf("source %q is not supported", dev.Source)
+	}
+
+	// Only the cloud needs the timeframe to be split, local devices page through their records.
+	interval := f.cfg.Timeframe.Interval
+	// Tariffs price hourly statistics which are rolled up into the interval afterwards.
+	fetchInterval := interval
+	devTariff, hasTariff := f.tariffs[dev.Tariff]
+	if hasTariff {
+		fetchInterval = config.IntervalHour
+	}
+	srcDev := sourceDevice(dev)
+	frames := []timeframe{{from: time.Time(f.cfg.Timeframe.From), to: time.Time(f.cfg.Timeframe.To)}}
+	if dev.Source == config.SourceCloud {
+		frames = chunks(fetchInterval, frames[0].from, frames[0].to)
+	}
+	statsFrames := make([]*shelly.PowerConsumptionStatistics, len(frames))
+	errs := make([]error, len(frames))
+	var wg sync.WaitGroup
+	for i, frame := range frames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case f.workers <- struct{}{}:
+				defer func() { <-f.workers }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+
+			statsFrames[i], errs[i] = source.PowerConsumption(ctx, srcDev, ch.Index, fetchInterval, frame.from, frame.to)
+			if errs[i] != nil {
+				cancel() // no need to fetch the remaining chunks
+			}
+		}()
+	}
+	wg.Wait()
+
+	if i := firstError(errs); i >= 0 {
+		return nil, fmt.Errorf("unable to pull statistics from %q to %q: %s", frames[i].from, frames[i].to, errs[i])
+	}
+
+	// Merge the chunks in order so that the result does not depend on the order of completion.
+	var stats *shelly.PowerConsumptionStatistics
+	for i, frame := range frames {
+		statsFrame := statsFrames[i]
+
+		if hasTariff {
+			devTariff.Apply(statsFrame.Stats)
+		}
+		normalizeChunk(statsFrame.Stats, frame, interval)
+		if stats == nil {
+			stats = statsFrame
+		} else {
+			report, err := stats.Stats.Add(statsFrame.Stats, f.cfg.MergePolicy)
+			if err != nil {
+				return nil, fmt.Errorf("unable to merge statistics: %s", err)
+			}
+			if report.Overlapping > 0 {
+				log.Printf("merged %d overlapping buckets of device %q (channel %d) at %q (%d conflicting, %d replaced)\n", report.Overlapping, dev.Name, ch.Index, frame.from, report.Conflicting, report.Replaced)
+			}
+		}
+	}
+
+	stats.Channel = ch // keep the label of the channel
+
+	from, to := frames[0].from, frames[len(frames)-1].to
+	if dropped := stats.Stats.Clip(from, to); len(dropped) > 0 {
+		log.Printf("dropped %d buckets of device %q (channel %d) outside of %q to %q, e.g. %s\n", len(dropped), dev.Name, ch.Index, from, to, dropped[0].Time.Format(time.RFC3339))
+	}
+
+	return stats, nil
+}
+
+// sourceDevice returns the device as passed to the sources.
+func sourceDevice(dev *config.Device) *shelly.Device {
+	return &shelly.Device{
+		ID:       dev.ID,
+		Name:     dev.Name,
+		Type:     dev.Type,
+		Timezone: dev.Timezone,
+		Host:     dev.Host,
+		Username: dev.Username,
+		Password: dev.Password,
+	}
+}
+
+// normalizeChunk restricts the statistics of the chunk to its timeframe at the fetched interval
+// and rolls them up into the given interval. The entries at date_to, which the cloud includes,
+// belong to the next chunk and would otherwise end up as a partial bucket (e.g. the first hour of
+// the next day) conflicting with the complete one of the next chunk.
+func normalizeChunk(s *shelly.Series, frame timeframe, interval string) {
+	s.Clip(frame.from, frame.to)
+	s.Normalize(interval)
+}
+
+// firstError returns the index of the first error which is not caused by cancelling the remaining
+// requests after a failure, or -1 if there is no error at all.
+func firstError(errs []error) int {
+	idx := -1
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return i
+		}
+		if idx < 0 {
+			idx = i
+		}
+	}
+	return idx
+}
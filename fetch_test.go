@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// testChunk returns the statistics of a chunk with an entry of 1 Wh per step from from to to, both
+// included like the Shelly cloud does for date_to.
+func testChunk(t *testing.T, interval string, from, to time.Time, step func(time.Time) time.Time) *shelly.Series {
+	t.Helper()
+	entries := []*shelly.Entry{}
+	for ts := from; !ts.After(to); ts = step(ts) {
+		entries = append(entries, &shelly.Entry{DateTime: shelly.ShellyTime(ts), Consumption: 1})
+	}
+	s, err := shelly.NewSeries(interval, time.UTC, [][]*shelly.Entry{entries})
+	if err != nil {
+		t.Fatalf("NewSeries() failed: %s", err)
+	}
+	return s
+}
+
+func nextHour(t time.Time) time.Time { return t.Add(time.Hour) }
+
+func TestNormalizeChunkMerge(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	frames := chunks(config.IntervalHour, day(1), day(3))
+
+	var merged *shelly.Series
+	for _, frame := range frames {
+		chunk := testChunk(t, config.IntervalHour, frame.from, frame.to, nextHour)
+		normalizeChunk(chunk, frame, config.IntervalDay)
+		if merged == nil {
+			merged = chunk
+			continue
+		}
+		if _, err := merged.Add(chunk, config.MergeError); err != nil {
+			t.Fatalf("Add() failed: %s", err)
+		}
+	}
+
+	if len(merged.Buckets) != 2 {
+		t.Fatalf("merged %d buckets, want 2", len(merged.Buckets))
+	}
+	for _, b := range merged.Buckets {
+		if b.Total.Consumption != 24 {
+			t.Errorf("bucket %s has %v Wh, want 24 Wh", b.Time, b.Total.Consumption)
+		}
+	}
+}
+
+func TestNormalizeChunkMidMonth(t *testing.T) {
+	from, to := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	frames := chunks(config.IntervalMonth, from, to)
+	if len(frames) != 1 {
+		t.Fatalf("chunks() returned %d chunks, want 1", len(frames))
+	}
+
+	// The cloud returns daily statistics up to and including date_to.
+	chunk := testChunk(t, config.IntervalDay, from, to, func(t time.Time) time.Time { return t.AddDate(0, 0, 1) })
+	normalizeChunk(chunk, frames[0], config.IntervalMonth)
+	if len(chunk.Buckets) != 1 {
+		t.Fatalf("normalized into %d buckets, want 1", len(chunk.Buckets))
+	}
+	if got := chunk.Buckets[0].Total.Consumption; got != 14 {
+		t.Errorf("March has %v Wh, want 14 Wh for the days before the 15th", got)
+	}
+}
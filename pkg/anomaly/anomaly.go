@@ -0,0 +1,184 @@
+// Package anomaly detects suspicious buckets in the statistics of a device, e.g. spikes, negative
+// consumption or meter resets, and annotates or quarantines them before they are exported.
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// Anomaly is a suspicious entry of a phase (or the total) of a series.
+type Anomaly struct {
+	Rule   string
+	Series string    // name of the phase or "total"
+	Time   time.Time // start of the bucket
+	Value  float64   // consumed energy in Wh
+	Severe bool      // whether the rule fails the run
+}
+
+// Label returns how the anomaly is annotated, e.g. "bounds" or "phase_a:z_score".
+func (a *Anomaly) Label() string {
+	if a.Series == shelly.TotalSeries {
+		return a.Rule
+	}
+	return fmt.Sprintf("%s:%s", a.Series, a.Rule)
+}
+
+// Check applies the rules of the validation to the series and returns the anomalies found. The
+// total of every suspicious bucket is annotated with its anomalies and, if configured, the bucket
+// is quarantined by removing its values so that it is treated as missing. Absent buckets are
+// skipped.
+func Check(s *shelly.Series, v *config.Validation) []*Anomaly {
+	anomalies := []*Anomaly{}
+	names := s.SeriesNames()
+	for i, entries := range s.Entries() {
+		anomalies = append(anomalies, checkBounds(entries, names[i], v)...)
+		if v.Enabled(config.RuleZScore) {
+			anomalies = append(anomalies, checkZScore(entries, names[i], v)...)
+		}
+	}
+	if v.Enabled(config.RuleZeroStreak) {
+		anomalies = append(anomalies, checkZeroStreak(s.Totals(), v)...)
+	}
+	if v.Enabled(config.RulePhaseSum) && len(s.Phases) > 0 {
+		anomalies = append(anomalies, checkPhaseSum(s.Buckets, v)...)
+	}
+
+	buckets := map[int64]*shelly.Bucket{}
+	for _, b := range s.Buckets {
+		buckets[b.Time.Unix()] = b
+	}
+	for _, a := range anomalies {
+		a.Severe = slices.Contains(v.FailOn, a.Rule)
+		b := buckets[a.Time.Unix()]
+		if !slices.Contains(b.Total.Anomalies, a.Label()) {
+			b.Total.Anomalies = append(b.Total.Anomalies, a.Label())
+		}
+		if v.Action == config.ActionQuarantine {
+			quarantine(b)
+		}
+	}
+	return anomalies
+}
+
+func newAnomaly(rule, series string, e *shelly.Entry) *Anomaly {
+	return &Anomaly{Rule: rule, Series: series, Time: time.Time(e.DateTime), Value: e.Consumption}
+}
+
+// checkBounds flags entries whose consumed or returned energy is outside of the bounds.
+func checkBounds(entries []*shelly.Entry, series string, v *config.Validation) []*Anomaly {
+	outside := func(wh float64) bool {
+		return wh < *v.Min || (v.Max != nil && wh > *v.Max)
+	}
+	anomalies := []*Anomaly{}
+	for _, e := range entries {
+		if !e.IsAbsent && (outside(e.Consumption) || outside(e.Reversed)) {
+			anomalies = append(anomalies, newAnomaly(config.RuleBounds, series, e))
+		}
+	}
+	return anomalies
+}
+
+// checkZScore flags entries whose consumption deviates from the mean of the preceding window by
+// more than the configured number of standard deviations.
+func checkZScore(entries []*shelly.Entry, series string, v *config.Validation) []*Anomaly {
+	anomalies := []*Anomaly{}
+	window := []float64{}
+	for _, e := range entries {
+		if e.IsAbsent {
+			continue
+		}
+		if len(window) > 1 {
+			mean, stddev := stats(window)
+			if stddev > 0 && math.Abs(e.Consumption-mean)/stddev > v.ZScore {
+				anomalies = append(anomalies, newAnomaly(config.RuleZScore, series, e))
+			}
+		}
+		window = append(window, e.Consumption)
+		if len(window) > v.Window {
+			window = window[1:]
+		}
+	}
+	return anomalies
+}
+
+// stats returns the mean and the standard deviation of the values.
+func stats(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+// checkZeroStreak flags runs of at least the configured number of totals without any consumption
+// which follow a total with consumption, e.g. after a meter reset.
+func checkZeroStreak(totals []*shelly.Entry, v *config.Validation) []*Anomaly {
+	anomalies := []*Anomaly{}
+	consumed := false
+	streak := []*shelly.Entry{}
+	flush := func() {
+		if consumed && len(streak) >= v.ZeroStreak {
+			for _, e := range streak {
+				anomalies = append(anomalies, newAnomaly(config.RuleZeroStreak, shelly.TotalSeries, e))
+			}
+		}
+		streak = nil
+	}
+	for _, e := range totals {
+		if e.IsAbsent {
+			continue
+		}
+		if e.Consumption == 0 {
+			streak = append(streak, e)
+			continue
+		}
+		flush()
+		consumed = true
+	}
+	flush()
+	return anomalies
+}
+
+// checkPhaseSum flags totals whose consumption differs from the sum of the phases by more than
+// the configured tolerance.
+func checkPhaseSum(buckets []*shelly.Bucket, v *config.Validation) []*Anomaly {
+	anomalies := []*Anomaly{}
+	for _, b := range buckets {
+		if b.Total.IsAbsent {
+			continue
+		}
+		var sum float64
+		complete := true
+		for _, e := range b.Phases {
+			complete = complete && !e.IsAbsent
+			sum += e.Consumption
+		}
+		if complete && math.Abs(sum-b.Total.Consumption) > v.PhaseSumTolerance {
+			anomalies = append(anomalies, newAnomaly(config.RulePhaseSum, shelly.TotalSeries, b.Total))
+		}
+	}
+	return anomalies
+}
+
+// quarantine removes the values of all entries of the bucket and marks them as missing.
+func quarantine(b *shelly.Bucket) {
+	for _, e := range append(slices.Clone(b.Phases), b.Total) {
+		*e = shelly.Entry{
+			DateTime:  e.DateTime,
+			IsMissing: true,
+			IsAbsent:  true,
+			Anomalies: e.Anomalies,
+		}
+	}
+}
@@ -5,31 +5,85 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"time"
 )
 
 const (
 	DateFmt = time.DateOnly
+
+	IntervalHour  = "hour"
+	IntervalDay   = "day"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+
+	SourceCloud = "cloud"
+	SourceGen1  = "gen1"
+	SourceGen2  = "gen2"
+	// SourceVirtual devices are computed from the statistics of other devices.
+	SourceVirtual = "virtual"
+
+	// MergeNewer keeps the bucket of the later chunk if a bucket is fetched more than once.
+	MergeNewer = "newer"
+	// MergeNonMissing keeps the bucket which is not missing, or else the one of the later chunk.
+	MergeNonMissing = "non_missing"
+	// MergeError fails if a bucket is fetched more than once with different values.
+	MergeError = "error"
+
+	ColumnConsumption = "consumption"
+	ColumnReturned    = "returned"
+	ColumnMinVoltage  = "min_voltage"
+	ColumnMaxVoltage  = "max_voltage"
+	ColumnCost        = "cost"
+	ColumnRevenue     = "revenue"
+
+	ColumnEffectivePrice = "effective_price"
+	ColumnBaselineCost   = "baseline_cost"
+	ColumnSavings        = "savings"
+	ColumnTariffID       = "tariff_id"
+	ColumnPurpose        = "purpose"
+	ColumnChannel        = "channel"
+	ColumnIsMissing      = "is_missing"
+	ColumnIsEstimated    = "is_estimated"
+	ColumnAnomalies      = "anomalies"
+
+	// ColumnTariffPeriods expands into the consumption and cost of each tariff period.
+	ColumnTariffPeriods     = "tariff_periods"
+	ColumnPeriodConsumption = "period_consumption"
+	ColumnPeriodCost        = "period_cost"
+
+	defaultUsername     = "admin"
+	defaultConcurrency  = 4
+	defaultRateLimit    = 1 // requests per second
+	defaultMaxRetries   = 3
+	defaultInitialDelay = ConfigDuration(time.Second)
+	defaultMaxDelay     = ConfigDuration(time.Minute)
 )
 
 var (
-	SupportedDeviceTypes = map[string]*DeviceType{
-		"em-3p": {
-			PathSuffix: "em-3p",
-			Phases:     3,
-		},
-		"em-1": {
-			PathSuffix: "",
-			Phases:     1,
-		},
+	// SupportedColumns lists the columns which can be exported for each bucket.
+	SupportedColumns = []string{
+		ColumnConsumption,
+		ColumnReturned,
+		ColumnMinVoltage,
+		ColumnMaxVoltage,
+		ColumnCost,
+		ColumnRevenue,
+		ColumnEffectivePrice,
+		ColumnBaselineCost,
+		ColumnSavings,
+		ColumnTariffPeriods,
+		ColumnTariffID,
+		ColumnPurpose,
+		ColumnChannel,
+		ColumnIsMissing,
+		ColumnIsEstimated,
+		ColumnAnomalies,
 	}
-)
 
-type DeviceType struct {
-	PathSuffix string
-	Phases     int
-}
+	defaultColumns = []string{ColumnConsumption, ColumnReturned, ColumnIsMissing}
+)
 
 type ConfigDate time.Time
 
@@ -59,33 +113,148 @@ func (d ConfigDate) Before(e ConfigDate) bool {
 	return time.Time(d).Before(time.Time(e))
 }
 
+type ConfigDuration time.Duration
+
+func (d *ConfigDuration) UnmarshalJSON(b []byte) error {
+	ts := strings.Trim(string(b), `"`)
+	t, err := time.ParseDuration(ts)
+	if err != nil {
+		return err
+	}
+	*d = ConfigDuration(t)
+	return nil
+}
+
+func (d ConfigDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
 type Config struct {
-	Timeframe   *Timeframe   `json:"timeframe"`
-	UserAgent   string       `json:"user_agent"`
-	Server      string       `json:"server"`
-	AuthKey     string       `json:"auth_key"`
-	Devices     []*Device    `json:"devices"`
-	GoogleSheet *GoogleSheet `json:"google_sheet"`
+	Timeframe   *Timeframe     `json:"timeframe"`
+	Timezone    string         `json:"timezone"`
+	UserAgent   string         `json:"user_agent"`
+	Server      string         `json:"server"`
+	AuthKey     string         `json:"auth_key"`
+	Timeout     ConfigDuration `json:"timeout"`
+	RateLimit   float64        `json:"requests_per_second"`
+	Retry       *Retry         `json:"retry"`
+	Concurrency int            `json:"concurrency"`
+	MergePolicy string         `json:"merge_policy"`
+	Columns     []string       `json:"columns"`
+	Format      *Format        `json:"format"`
+	Rollups     []*Rollup      `json:"rollups"`
+	Fill        string         `json:"fill"`
+	Validation  *Validation    `json:"validation"`
+	Tariffs     []*Tariff      `json:"tariffs"`
+	Devices     []*Device      `json:"devices"`
+	Sites       []*Site        `json:"sites"`
+	GoogleSheet *GoogleSheet   `json:"google_sheet"`
+}
+
+type Retry struct {
+	MaxRetries   int            `json:"max_retries"`
+	InitialDelay ConfigDuration `json:"initial_delay"`
+	MaxDelay     ConfigDuration `json:"max_delay"`
 }
 
 type Timeframe struct {
 	From         ConfigDate `json:"from"`
 	To           ConfigDate `json:"to"`
 	LookbackDays int        `json:"lookback_days"`
+	Interval     string     `json:"interval"`
 }
 
 type Device struct {
 	ID          string       `json:"id"`
 	Name        string       `json:"name,omitempty"`
 	Type        string       `json:"type"`
-	IsDisabled  bool         `json:"disabled"`
-	GoogleSheet *GoogleSheet `json:"google_sheet"`
+	Channels    []*Channel   `json:"channels,omitempty"`
+	Columns     []string     `json:"columns,omitempty"`
+	Format      *Format      `json:"format,omitempty"`
+	Rollups     []*Rollup    `json:"rollups,omitempty"`
+	Fill        string       `json:"fill,omitempty"`
+	Validation  *Validation  `json:"validation,omitempty"`
+	Tariff      string       `json:"tariff,omitempty"`
+	Terms       []*Term      `json:"terms,omitempty"`
+	Timezone    string       `json:"timezone,omitempty"`
+	Source      string       `json:"source,omitempty"`
+	Host        string       `json:"host,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	Password    string       `json:"password,omitempty"`
+	IsDisabled  bool         `json:"disabled,omitempty"`
+	GoogleSheet *GoogleSheet `json:"google_sheet,omitempty"`
+}
+
+type Channel struct {
+	Index int    `json:"index"`
+	Label string `json:"label,omitempty"`
+}
+
+// Name returns the label of the channel or a name derived from its index if no label is set.
+func (c *Channel) Name() string {
+	if c.Label != "" {
+		return c.Label
+	}
+	return fmt.Sprintf("ch%d", c.Index)
 }
 
 type GoogleSheet struct {
-	SvcAcctKey    string `json:"service_account_key"`
-	SheetID       string `json:"sheet_id"`
-	SpreadsheetID string `json:"spreadsheet_id"`
+	SvcAcctKey    string  `json:"service_account_key"`
+	SheetID       string  `json:"sheet_id"`
+	SpreadsheetID string  `json:"spreadsheet_id"`
+	Format        *Format `json:"format,omitempty"` // default: the format of the device
+}
+
+// validateGoogleSheet fills in the settings missing for the export to a sheet from the global ones
+// (and the format from the one of the device or site) and returns an error if they are still
+// incomplete.
+func validateGoogleSheet(gs, global *GoogleSheet, format *Format) error {
+	if gs == nil {
+		return nil
+	}
+	if gs.Format == nil && global != nil {
+		gs.Format = global.Format
+	}
+	if gs.Format == nil {
+		gs.Format = format
+	}
+	if err := validateFormat(gs.Format); err != nil {
+		return fmt.Errorf("invalid format: %s", err)
+	}
+	if gs.SheetID == "" && global != nil {
+		gs.SheetID = global.SheetID
+	}
+	if gs.SheetID == "" {
+		return errors.New("sheet_id must be set (or globally)")
+	}
+	if gs.SpreadsheetID == "" && global != nil {
+		gs.SpreadsheetID = global.SpreadsheetID
+	}
+	if gs.SpreadsheetID == "" {
+		return errors.New("spreadsheet_id must be set (or globally)")
+	}
+	if gs.SvcAcctKey == "" && global != nil {
+		gs.SvcAcctKey = global.SvcAcctKey
+	}
+	if gs.SvcAcctKey == "" {
+		return errors.New("service_account_key must be set (or globally)")
+	}
+	return nil
+}
+
+// validateColumns returns an error if a column is not supported or set multiple times.
+func validateColumns(columns []string) error {
+	seen := map[string]bool{}
+	for _, col := range columns {
+		if !slices.Contains(SupportedColumns, col) {
+			return fmt.Errorf("column %q is not supported", col)
+		}
+		if seen[col] {
+			return fmt.Errorf("column %q is set multiple times", col)
+		}
+		seen[col] = true
+	}
+	return nil
 }
 
 func Validate(config *Config) error {
@@ -105,6 +274,61 @@ func Validate(config *Config) error {
 	if config.Timeframe.To.Before(config.Timeframe.From) {
 		return errors.New("from date needs to be before to date")
 	}
+	switch config.Timeframe.Interval {
+	case "":
+		config.Timeframe.Interval = IntervalDay
+	case IntervalHour, IntervalDay, IntervalMonth, IntervalYear:
+	default:
+		return fmt.Errorf("interval %q is not supported", config.Timeframe.Interval)
+	}
+
+	// Timezone
+	if _, err := time.LoadLocation(config.Timezone); err != nil {
+		return fmt.Errorf("timezone %q is not valid: %s", config.Timezone, err)
+	}
+
+	// Columns
+	if len(config.Columns) == 0 {
+		config.Columns = defaultColumns
+	}
+	if err := validateColumns(config.Columns); err != nil {
+		return err
+	}
+
+	// Format
+	if err := validateFormat(config.Format); err != nil {
+		return fmt.Errorf("invalid format: %s", err)
+	}
+
+	// Rollups
+	if err := validateRollups(config.Rollups, config.Timeframe.Interval); err != nil {
+		return fmt.Errorf("invalid rollups: %s", err)
+	}
+
+	// Gaps
+	if config.Fill == "" {
+		config.Fill = FillEmpty
+	}
+	if err := validateFill(config.Fill, config.Timeframe.Interval); err != nil {
+		return err
+	}
+
+	// Validation
+	if err := validateValidation(config.Validation); err != nil {
+		return fmt.Errorf("invalid validation: %s", err)
+	}
+
+	// Tariffs
+	tariffIDs := map[string]bool{}
+	for i, t := range config.Tariffs {
+		if err := validateTariff(t); err != nil {
+			return fmt.Errorf("invalid tariff %d: %s", i, err)
+		}
+		if tariffIDs[t.ID] {
+			return fmt.Errorf("tariff %q is set multiple times", t.ID)
+		}
+		tariffIDs[t.ID] = true
+	}
 
 	// Google Sheet
 	if config.GoogleSheet != nil {
@@ -117,47 +341,153 @@ func Validate(config *Config) error {
 	if len(config.Devices) == 0 {
 		return errors.New("at least one device needs to be set")
 	}
+	usesCloud := false
 	for i, dev := range config.Devices {
-		if dev.ID == "" {
-			return fmt.Errorf("device ID needs to be set for device %d", i)
-		}
-		if _, ok := SupportedDeviceTypes[strings.ToLower(dev.Type)]; !ok {
-			return fmt.Errorf("device type %q is not supported", dev.Type)
-		}
-		if dev.GoogleSheet != nil {
-			if dev.GoogleSheet.SheetID == "" && config.GoogleSheet != nil {
-				dev.GoogleSheet.SheetID = config.GoogleSheet.SheetID
+		switch dev.Source {
+		case "":
+			dev.Source = SourceCloud
+			fallthrough
+		case SourceCloud:
+			if dev.ID == "" {
+				return fmt.Errorf("device ID needs to be set for device %d", i)
 			}
-			if dev.GoogleSheet.SheetID == "" {
-				return fmt.Errorf("sheet_id must be set for device %d (or globally)", i)
+			usesCloud = usesCloud || !dev.IsDisabled
+		case SourceGen1, SourceGen2:
+			if dev.Host == "" {
+				return fmt.Errorf("host needs to be set for local device %d", i)
 			}
-			if dev.GoogleSheet.SpreadsheetID == "" && config.GoogleSheet != nil {
-				dev.GoogleSheet.SpreadsheetID = config.GoogleSheet.SpreadsheetID
+			if dev.Username == "" {
+				dev.Username = defaultUsername
 			}
-			if dev.GoogleSheet.SpreadsheetID == "" {
-				return fmt.Errorf("spreadsheet_id must be set for device %d (or globally)", i)
+		case SourceVirtual:
+			if err := validateVirtualDevice(config, i); err != nil {
+				return fmt.Errorf("invalid virtual device %d: %s", i, err)
 			}
-			if dev.GoogleSheet.SvcAcctKey == "" && config.GoogleSheet != nil {
-				dev.GoogleSheet.SvcAcctKey = config.GoogleSheet.SvcAcctKey
+		default:
+			return fmt.Errorf("source %q of device %d is not supported", dev.Source, i)
+		}
+		if dev.Timezone == "" {
+			dev.Timezone = config.Timezone
+		}
+		if _, err := time.LoadLocation(dev.Timezone); err != nil {
+			return fmt.Errorf("timezone %q of device %d is not valid: %s", dev.Timezone, i, err)
+		}
+		if len(dev.Columns) == 0 {
+			dev.Columns = config.Columns
+		}
+		if err := validateColumns(dev.Columns); err != nil {
+			return fmt.Errorf("invalid columns for device %d: %s", i, err)
+		}
+		var tariff *Tariff
+		if dev.Tariff != "" {
+			t, ok := config.FindTariff(dev.Tariff)
+			if !ok {
+				return fmt.Errorf("tariff %q of device %d is not defined", dev.Tariff, i)
 			}
-			if dev.GoogleSheet.SvcAcctKey == "" {
-				return fmt.Errorf("service_account_key must be set for device %d (or globally)", i)
+			tariff = t
+		}
+		dev.Columns = tariffColumns(dev.Columns, tariff)
+		if dev.Fill == "" {
+			dev.Fill = config.Fill
+		}
+		if err := validateFill(dev.Fill, config.Timeframe.Interval); err != nil {
+			return fmt.Errorf("invalid fill for device %d: %s", i, err)
+		}
+		dev.Columns = gapColumns(dev.Columns, dev.Fill)
+		if dev.Validation == nil {
+			dev.Validation = config.Validation
+		}
+		if err := validateValidation(dev.Validation); err != nil {
+			return fmt.Errorf("invalid validation for device %d: %s", i, err)
+		}
+		dev.Columns = validationColumns(dev.Columns, dev.Validation)
+		channels := map[int]bool{}
+		for _, ch := range dev.Channels {
+			if ch.Index < 0 {
+				return fmt.Errorf("channel index cannot be negative for device %d", i)
 			}
+			if channels[ch.Index] {
+				return fmt.Errorf("channel %d is set multiple times for device %d", ch.Index, i)
+			}
+			channels[ch.Index] = true
+		}
+		if dev.Format == nil {
+			dev.Format = config.Format
+		}
+		if err := validateFormat(dev.Format); err != nil {
+			return fmt.Errorf("invalid format for device %d: %s", i, err)
+		}
+		if dev.Rollups == nil {
+			dev.Rollups = config.Rollups
+		}
+		if err := validateRollups(dev.Rollups, config.Timeframe.Interval); err != nil {
+			return fmt.Errorf("invalid rollups for device %d: %s", i, err)
+		}
+		if err := validateGoogleSheet(dev.GoogleSheet, config.GoogleSheet, dev.Format); err != nil {
+			return fmt.Errorf("invalid google_sheet for device %d: %s", i, err)
 		}
 	}
 
+	// Sites
+	if err := validateSites(config); err != nil {
+		return err
+	}
+
 	// Auth
-	if config.Server == "" {
+	if usesCloud && config.Server == "" {
 		return errors.New("server needs to be set")
 	}
-	if config.AuthKey == "" {
+	if usesCloud && config.AuthKey == "" {
 		return errors.New("auth key needs to be set")
 	}
+	if config.Timeout < 0 {
+		return errors.New("timeout cannot be negative")
+	}
+
+	// Throttling
+	if config.RateLimit < 0 {
+		return errors.New("requests_per_second cannot be negative")
+	}
+	if config.RateLimit == 0 {
+		config.RateLimit = defaultRateLimit
+	}
+	if config.Concurrency < 0 {
+		return errors.New("concurrency cannot be negative")
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = defaultConcurrency
+	}
+	switch config.MergePolicy {
+	case "":
+		config.MergePolicy = MergeNewer
+	case MergeNewer, MergeNonMissing, MergeError:
+	default:
+		return fmt.Errorf("merge_policy %q is not supported", config.MergePolicy)
+	}
+	if config.Retry == nil {
+		config.Retry = &Retry{MaxRetries: defaultMaxRetries}
+	}
+	if config.Retry.MaxRetries < 0 {
+		return errors.New("max_retries cannot be negative")
+	}
+	if config.Retry.InitialDelay < 0 || config.Retry.MaxDelay < 0 {
+		return errors.New("retry delays cannot be negative")
+	}
+	if config.Retry.InitialDelay == 0 {
+		config.Retry.InitialDelay = defaultInitialDelay
+	}
+	if config.Retry.MaxDelay == 0 {
+		config.Retry.MaxDelay = defaultMaxDelay
+	}
+	if config.Retry.MaxDelay < config.Retry.InitialDelay {
+		return errors.New("max_delay needs to be at least initial_delay")
+	}
 
 	return nil
 }
 
-func ReadFromFile(file string) (*Config, error) {
+// Parse reads the config from the file without validating it.
+func Parse(file string) (*Config, error) {
 	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read config from %q: %s", file, err)
@@ -167,14 +497,26 @@ func ReadFromFile(file string) (*Config, error) {
 	if err := json.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("unable to parse JSON in %q: %s", file, err)
 	}
+	return config, nil
+}
+
+func ReadFromFile(file string) (*Config, error) {
+	config, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := Validate(config); err != nil {
 		return nil, err
 	}
 
 	if config.Timeframe.LookbackDays > 0 {
-		config.Timeframe.From = ConfigDate(time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -config.Timeframe.LookbackDays))
-		config.Timeframe.To = ConfigDate(time.Now().UTC().Truncate(24 * time.Hour))
+		// Dates are calendar dates, so "today" depends on the configured timezone (UTC if unset).
+		loc, _ := time.LoadLocation(config.Timezone)
+		now := time.Now().In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		config.Timeframe.From = ConfigDate(today.AddDate(0, 0, -config.Timeframe.LookbackDays))
+		config.Timeframe.To = ConfigDate(today)
 	}
 
 	return config, nil
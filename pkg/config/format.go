@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	UnitWh  = "Wh"
+	UnitKWh = "kWh"
+	UnitMWh = "MWh"
+
+	RoundingHalfUp   = "half_up"
+	RoundingHalfEven = "half_even"
+	RoundingDown     = "down"
+	RoundingUp       = "up"
+
+	maxDecimals = 10
+)
+
+var (
+	units = map[string]float64{
+		UnitWh:  1,
+		UnitKWh: 1e3,
+		UnitMWh: 1e6,
+	}
+)
+
+// Format defines how the values of an export are written. Energy is converted into the unit, which
+// is then appended to the names of the energy columns (e.g. "total_kwh"). If decimals are set, all
+// numbers are rounded accordingly.
+type Format struct {
+	Unit     string `json:"unit,omitempty"`     // Wh (default), kWh or MWh
+	Decimals *int   `json:"decimals,omitempty"` // default: unrounded
+	Rounding string `json:"rounding,omitempty"` // half_up (default), half_even, down or up
+}
+
+// Divisor returns the divisor converting Wh into the unit of the format.
+func (f *Format) Divisor() float64 {
+	if f == nil || f.Unit == "" {
+		return 1
+	}
+	return units[f.Unit]
+}
+
+// IsEnergyColumn returns whether the field of a column (see SupportedColumns) holds energy in Wh.
+func IsEnergyColumn(field string) bool {
+	if f, _, ok := strings.Cut(field, ":"); ok {
+		return f == ColumnPeriodConsumption
+	}
+	return field == ColumnConsumption || field == ColumnReturned
+}
+
+// IsAdditiveColumn returns whether the values of a field (see SupportedColumns) can be summed up.
+func IsAdditiveColumn(field string) bool {
+	if _, _, ok := strings.Cut(field, ":"); ok {
+		return true // consumption and cost per tariff period
+	}
+	switch field {
+	case ColumnConsumption, ColumnReturned, ColumnCost, ColumnRevenue, ColumnBaselineCost, ColumnSavings:
+		return true
+	default:
+		return false
+	}
+}
+
+func validateFormat(f *Format) error {
+	if f == nil {
+		return nil
+	}
+	if f.Unit != "" {
+		found := false
+		for unit := range units {
+			if strings.EqualFold(unit, f.Unit) {
+				f.Unit = unit
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unit %q is not supported", f.Unit)
+		}
+	}
+	if f.Decimals != nil && (*f.Decimals < 0 || *f.Decimals > maxDecimals) {
+		return fmt.Errorf("decimals need to be between 0 and %d", maxDecimals)
+	}
+	switch f.Rounding {
+	case "":
+		if f.Decimals != nil {
+			f.Rounding = RoundingHalfUp
+		}
+	case RoundingHalfUp, RoundingHalfEven, RoundingDown, RoundingUp:
+		if f.Decimals == nil {
+			return errors.New("rounding requires decimals to be set")
+		}
+	default:
+		return fmt.Errorf("rounding %q is not supported", f.Rounding)
+	}
+	return nil
+}
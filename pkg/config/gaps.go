@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// FillEmpty leaves missing buckets empty.
+	FillEmpty = "empty"
+	// FillZero assumes nothing was consumed or returned in missing buckets.
+	FillZero = "zero"
+	// FillLinear interpolates linearly between the buckets before and after a gap.
+	FillLinear = "linear"
+	// FillWeekday uses the average of the buckets on the same weekday (and hour).
+	FillWeekday = "weekday"
+)
+
+var (
+	fillStrategies = []string{FillEmpty, FillZero, FillLinear, FillWeekday}
+)
+
+func validateFill(fill, interval string) error {
+	if !slices.Contains(fillStrategies, fill) {
+		return fmt.Errorf("fill strategy %q is not supported", fill)
+	}
+	if fill == FillWeekday && interval != IntervalHour && interval != IntervalDay {
+		return fmt.Errorf("fill strategy %q is not supported for interval %q", fill, interval)
+	}
+	return nil
+}
+
+// gapColumns adds the column flagging estimated buckets to the columns of a device whose gaps are
+// filled if it is missing.
+func gapColumns(columns []string, fill string) []string {
+	if fill == FillEmpty || slices.Contains(columns, ColumnIsEstimated) {
+		return columns
+	}
+	return append(slices.Clone(columns), ColumnIsEstimated)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	RollupWeek    = "week" // ISO week starting on Monday
+	RollupMonth   = IntervalMonth
+	RollupYear    = IntervalYear
+	RollupBilling = "billing" // month starting on the billing start day
+
+	maxBillingStartDay = 28
+)
+
+// Rollup aggregates the exported buckets into coarser periods which are exported separately.
+type Rollup struct {
+	Period          string `json:"period"`                      // week, month, year or billing
+	BillingStartDay int    `json:"billing_start_day,omitempty"` // 1 to 28, for billing periods
+}
+
+// Name returns the name of the rollup used for its file and sheet.
+func (r *Rollup) Name() string {
+	return r.Period
+}
+
+func validateRollups(rollups []*Rollup, interval string) error {
+	periods := map[string]bool{}
+	for _, r := range rollups {
+		// Buckets need to be finer than the rollup period to be assigned to exactly one period.
+		var finer []string
+		switch r.Period {
+		case RollupWeek, RollupMonth:
+			finer = []string{IntervalHour, IntervalDay}
+		case RollupYear:
+			finer = []string{IntervalHour, IntervalDay, IntervalMonth}
+		case RollupBilling:
+			finer = []string{IntervalHour, IntervalDay}
+			if r.BillingStartDay < 1 || r.BillingStartDay > maxBillingStartDay {
+				return fmt.Errorf("billing_start_day needs to be between 1 and %d", maxBillingStartDay)
+			}
+		default:
+			return fmt.Errorf("rollup period %q is not supported", r.Period)
+		}
+		if !slices.Contains(finer, interval) {
+			return fmt.Errorf("rollup period %q is not supported for interval %q", r.Period, interval)
+		}
+		if periods[r.Period] {
+			return fmt.Errorf("rollup period %q is set multiple times", r.Period)
+		}
+		periods[r.Period] = true
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Site combines the meter of the grid connection with the meters of the local production (e.g. PV
+// inverters) to analyse the self-consumption and autarky of the site. Devices are referenced by
+// their name.
+type Site struct {
+	Name                 string       `json:"name"`
+	Grid                 string       `json:"grid"`
+	Production           []string     `json:"production"`
+	ProductionIsReturned bool         `json:"production_is_returned"` // production is metered as returned energy
+	Format               *Format      `json:"format,omitempty"`
+	GoogleSheet          *GoogleSheet `json:"google_sheet,omitempty"`
+}
+
+// FindDevice returns the device with the given name.
+func (c *Config) FindDevice(name string) (*Device, bool) {
+	for _, dev := range c.Devices {
+		if dev.Name == name {
+			return dev, true
+		}
+	}
+	return nil, false
+}
+
+func validateSites(config *Config) error {
+	names := map[string]bool{}
+	for i, site := range config.Sites {
+		if site.Name == "" {
+			return fmt.Errorf("name needs to be set for site %d", i)
+		}
+		if names[site.Name] {
+			return fmt.Errorf("site %q is set multiple times", site.Name)
+		}
+		names[site.Name] = true
+		if site.Grid == "" {
+			return fmt.Errorf("grid needs to be set for site %q", site.Name)
+		}
+		if len(site.Production) == 0 {
+			return fmt.Errorf("at least one production device needs to be set for site %q", site.Name)
+		}
+		for _, name := range append([]string{site.Grid}, site.Production...) {
+			if err := validateReference(config, name); err != nil {
+				return fmt.Errorf("invalid device for site %q: %s", site.Name, err)
+			}
+		}
+		if site.Format == nil {
+			site.Format = config.Format
+		}
+		if err := validateFormat(site.Format); err != nil {
+			return fmt.Errorf("invalid format for site %q: %s", site.Name, err)
+		}
+		if err := validateGoogleSheet(site.GoogleSheet, config.GoogleSheet, site.Format); err != nil {
+			return fmt.Errorf("invalid google_sheet for site %q: %s", site.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateReference returns an error if the device referenced by name does not exist, is not
+// unique or is disabled.
+func validateReference(config *Config, name string) error {
+	found := 0
+	for _, dev := range config.Devices {
+		if dev.Name != name {
+			continue
+		}
+		found++
+		if dev.IsDisabled {
+			return fmt.Errorf("device %q is disabled", name)
+		}
+	}
+	switch found {
+	case 0:
+		return fmt.Errorf("device %q does not exist", name)
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("device name %q is not unique", name)
+	}
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// TariffBasePeriod is the name of the period used when no period of a tariff matches.
+	TariffBasePeriod = "base"
+	// TariffSpotPeriod is the name of the period priced by the price file of a tariff.
+	TariffSpotPeriod = "spot"
+
+	tariffTimeFmt = "15:04"
+)
+
+var (
+	tariffDays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun", "weekday", "weekend", "holiday"}
+)
+
+// Tariff is a time-of-use or dynamic tariff used to compute the cost of consumed and the revenue
+// of returned energy locally. Dynamic tariffs read their prices from a file; the versions then
+// only apply where the file has no price.
+type Tariff struct {
+	ID            string           `json:"id"`
+	Holidays      []ConfigDate     `json:"holidays"`
+	Versions      []*TariffVersion `json:"versions"`
+	PriceFile     string           `json:"price_file"`     // CSV or JSON with prices per kWh
+	BaselinePrice float64          `json:"baseline_price"` // per kWh, to compare the cost with
+}
+
+// TariffVersion holds the prices of a tariff from a given day on until the next version.
+type TariffVersion struct {
+	ValidFrom   ConfigDate      `json:"valid_from"`
+	Price       float64         `json:"price"`         // per kWh, if no period matches
+	FeedInPrice float64         `json:"feed_in_price"` // per kWh returned
+	Periods     []*TariffPeriod `json:"periods"`
+}
+
+// TariffPeriod is a time window with a different price, e.g. peak hours on weekdays.
+type TariffPeriod struct {
+	Name  string   `json:"name"`
+	Price float64  `json:"price"` // per kWh
+	Days  []string `json:"days"`  // mon to sun, weekday, weekend or holiday (default: all)
+	From  string   `json:"from"`  // HH:MM, inclusive
+	To    string   `json:"to"`    // HH:MM, exclusive, may be before from to wrap around midnight
+}
+
+// Version returns the version of the tariff valid on the day of t (by its wall clock) or nil if
+// there is none.
+func (t *Tariff) Version(ts time.Time) *TariffVersion {
+	day := time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+	var version *TariffVersion
+	for _, v := range t.Versions {
+		if time.Time(v.ValidFrom).After(day) {
+			break
+		}
+		version = v
+	}
+	return version
+}
+
+// IsHoliday returns whether the day of t (by its wall clock) is a holiday.
+func (t *Tariff) IsHoliday(ts time.Time) bool {
+	for _, h := range t.Holidays {
+		if h.Format(DateFmt) == ts.Format(DateFmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeriodNames returns the names of all periods of the tariff in the order they are defined
+// followed by the base period. Dynamic tariffs start with the spot period and only have a base
+// period if they also have versions.
+func (t *Tariff) PeriodNames() []string {
+	names := []string{}
+	if t.PriceFile != "" {
+		names = append(names, TariffSpotPeriod)
+		if len(t.Versions) == 0 {
+			return names
+		}
+	}
+	for _, v := range t.Versions {
+		for _, p := range v.Periods {
+			if !slices.Contains(names, p.Name) {
+				names = append(names, p.Name)
+			}
+		}
+	}
+	return append(names, TariffBasePeriod)
+}
+
+// Matches returns whether the period applies at the wall clock time of t. Holidays only match
+// periods for holidays and weekends.
+func (p *TariffPeriod) Matches(ts time.Time, isHoliday bool) bool {
+	if len(p.Days) > 0 {
+		day := strings.ToLower(ts.Weekday().String()[:3])
+		weekend := ts.Weekday() == time.Saturday || ts.Weekday() == time.Sunday
+		matches := false
+		for _, d := range p.Days {
+			switch d {
+			case "holiday":
+				matches = matches || isHoliday
+			case "weekend":
+				matches = matches || weekend || isHoliday
+			case "weekday":
+				matches = matches || (!weekend && !isHoliday)
+			default:
+				matches = matches || (d == day && !isHoliday)
+			}
+		}
+		if !matches {
+			return false
+		}
+	}
+
+	// The times were validated already.
+	from, _ := time.Parse(tariffTimeFmt, p.From)
+	to, _ := time.Parse(tariffTimeFmt, p.To)
+	start := from.Hour()*60 + from.Minute()
+	end := to.Hour()*60 + to.Minute()
+	minute := ts.Hour()*60 + ts.Minute()
+	switch {
+	case start < end:
+		return minute >= start && minute < end
+	case start > end:
+		return minute >= start || minute < end
+	default:
+		return true
+	}
+}
+
+// FindTariff returns the tariff with the given ID.
+func (c *Config) FindTariff(id string) (*Tariff, bool) {
+	for _, t := range c.Tariffs {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func validateTariff(t *Tariff) error {
+	if t.ID == "" {
+		return errors.New("id needs to be set")
+	}
+	if len(t.Versions) == 0 && t.PriceFile == "" {
+		return errors.New("at least one version or a price_file needs to be set")
+	}
+	if t.BaselinePrice < 0 {
+		return errors.New("baseline_price cannot be negative")
+	}
+	sort.Slice(t.Versions, func(i, j int) bool {
+		return t.Versions[i].ValidFrom.Before(t.Versions[j].ValidFrom)
+	})
+	for i, v := range t.Versions {
+		if v.Price < 0 || v.FeedInPrice < 0 {
+			return fmt.Errorf("prices of version %d cannot be negative", i)
+		}
+		names := map[string]bool{}
+		for j, p := range v.Periods {
+			if p.Name == "" || p.Name == TariffBasePeriod || p.Name == TariffSpotPeriod {
+				return fmt.Errorf("period %d of version %d needs a name other than %q and %q", j, i, TariffBasePeriod, TariffSpotPeriod)
+			}
+			if names[p.Name] {
+				return fmt.Errorf("period %q is set multiple times in version %d", p.Name, i)
+			}
+			names[p.Name] = true
+			if p.Price < 0 {
+				return fmt.Errorf("price of period %q cannot be negative", p.Name)
+			}
+			for _, d := range p.Days {
+				if !slices.Contains(tariffDays, d) {
+					return fmt.Errorf("day %q of period %q is not supported", d, p.Name)
+				}
+			}
+			if p.From == "" {
+				p.From = "00:00"
+			}
+			if p.To == "" {
+				p.To = "00:00"
+			}
+			if _, err := time.Parse(tariffTimeFmt, p.From); err != nil {
+				return fmt.Errorf("from of period %q is not valid: %s", p.Name, err)
+			}
+			if _, err := time.Parse(tariffTimeFmt, p.To); err != nil {
+				return fmt.Errorf("to of period %q is not valid: %s", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PeriodColumn returns the name of the column holding the given field (ColumnPeriodConsumption or
+// ColumnPeriodCost) for the tariff period.
+func PeriodColumn(field, period string) string {
+	return fmt.Sprintf("%s:%s", field, period)
+}
+
+// tariffColumns adds the cost, revenue and tariff period columns (and the effective price for
+// dynamic tariffs as well as the comparison with the baseline if set) to the columns of a device
+// with a tariff if they are missing and expands the tariff periods into a consumption and cost
+// column per period.
+func tariffColumns(columns []string, t *Tariff) []string {
+	if t != nil {
+		required := []string{ColumnCost, ColumnRevenue, ColumnTariffPeriods}
+		if t.PriceFile != "" {
+			required = append(required, ColumnEffectivePrice)
+		}
+		if t.BaselinePrice > 0 {
+			required = append(required, ColumnBaselineCost, ColumnSavings)
+		}
+		for _, col := range required {
+			if !slices.Contains(columns, col) {
+				columns = append(slices.Clone(columns), col)
+			}
+		}
+	}
+
+	expanded := []string{}
+	for _, col := range columns {
+		if col != ColumnTariffPeriods {
+			expanded = append(expanded, col)
+			continue
+		}
+		if t == nil {
+			continue
+		}
+		for _, name := range t.PeriodNames() {
+			expanded = append(expanded, PeriodColumn(ColumnPeriodConsumption, name), PeriodColumn(ColumnPeriodCost, name))
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTariffPeriodMatches(t *testing.T) {
+	monday := func(hour, minute int) time.Time { return time.Date(2024, 3, 4, hour, minute, 0, 0, time.UTC) }
+	saturday := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		period  *TariffPeriod
+		ts      time.Time
+		holiday bool
+		want    bool
+	}{
+		{name: "within", period: &TariffPeriod{From: "07:00", To: "20:00"}, ts: monday(7, 0), want: true},
+		{name: "to is exclusive", period: &TariffPeriod{From: "07:00", To: "20:00"}, ts: monday(20, 0), want: false},
+		{name: "before from", period: &TariffPeriod{From: "07:00", To: "20:00"}, ts: monday(6, 59), want: false},
+		{name: "wrap before midnight", period: &TariffPeriod{From: "22:00", To: "06:00"}, ts: monday(23, 30), want: true},
+		{name: "wrap after midnight", period: &TariffPeriod{From: "22:00", To: "06:00"}, ts: monday(5, 59), want: true},
+		{name: "wrap outside", period: &TariffPeriod{From: "22:00", To: "06:00"}, ts: monday(6, 0), want: false},
+		{name: "from equals to", period: &TariffPeriod{From: "00:00", To: "00:00"}, ts: monday(13, 0), want: true},
+		{name: "weekday on monday", period: &TariffPeriod{Days: []string{"weekday"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), want: true},
+		{name: "weekday on saturday", period: &TariffPeriod{Days: []string{"weekday"}, From: "00:00", To: "00:00"}, ts: saturday, want: false},
+		{name: "weekday on holiday", period: &TariffPeriod{Days: []string{"weekday"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), holiday: true, want: false},
+		{name: "weekend on holiday", period: &TariffPeriod{Days: []string{"weekend"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), holiday: true, want: true},
+		{name: "holiday on holiday", period: &TariffPeriod{Days: []string{"holiday"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), holiday: true, want: true},
+		{name: "holiday on monday", period: &TariffPeriod{Days: []string{"holiday"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), want: false},
+		{name: "explicit day", period: &TariffPeriod{Days: []string{"mon"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), want: true},
+		{name: "explicit day on holiday", period: &TariffPeriod{Days: []string{"mon"}, From: "00:00", To: "00:00"}, ts: monday(12, 0), holiday: true, want: false},
+		{name: "other explicit day", period: &TariffPeriod{Days: []string{"tue", "sat"}, From: "00:00", To: "00:00"}, ts: saturday, want: true},
+		{name: "day and time", period: &TariffPeriod{Days: []string{"sat"}, From: "07:00", To: "11:00"}, ts: saturday, want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.period.Matches(tc.ts, tc.holiday); got != tc.want {
+				t.Errorf("Matches(%s, %t) = %t, want %t", tc.ts, tc.holiday, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTariffVersion(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("timezone database not available: %s", err)
+	}
+	first := &TariffVersion{ValidFrom: ConfigDate(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), Price: 0.25}
+	second := &TariffVersion{ValidFrom: ConfigDate(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)), Price: 0.22}
+	tariff := &Tariff{ID: "test", Versions: []*TariffVersion{second, first}}
+	if err := validateTariff(tariff); err != nil {
+		t.Fatalf("validateTariff() failed: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		ts   time.Time
+		want *TariffVersion
+	}{
+		{name: "before first", ts: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC), want: nil},
+		{name: "first day", ts: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), want: first},
+		{name: "last day of first", ts: time.Date(2024, 6, 30, 23, 30, 0, 0, zurich), want: first},
+		{name: "switch by wall clock", ts: time.Date(2024, 7, 1, 0, 30, 0, 0, zurich), want: second},
+		{name: "after last", ts: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), want: second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tariff.Version(tc.ts); got != tc.want {
+				t.Errorf("Version(%s) = %+v, want %+v", tc.ts, got, tc.want)
+			}
+		})
+	}
+}
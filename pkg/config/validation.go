@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	// RuleBounds flags consumed or returned energy outside of the min and max per bucket.
+	RuleBounds = "bounds"
+	// RuleZScore flags consumption deviating from the rolling window by more than z_score standard
+	// deviations.
+	RuleZScore = "z_score"
+	// RuleZeroStreak flags runs of buckets without consumption following consumption, e.g. after a
+	// meter reset.
+	RuleZeroStreak = "zero_streak"
+	// RulePhaseSum flags totals which differ from the sum of the phases.
+	RulePhaseSum = "phase_sum"
+
+	// ActionAnnotate exports suspicious buckets along with their anomalies.
+	ActionAnnotate = "annotate"
+	// ActionQuarantine additionally removes the values of suspicious buckets, which are then
+	// treated as missing.
+	ActionQuarantine = "quarantine"
+
+	defaultZScoreWindow = 24 // buckets
+)
+
+var (
+	validationRules = []string{RuleBounds, RuleZScore, RuleZeroStreak, RulePhaseSum}
+)
+
+// Validation configures the rules used to detect anomalies (e.g. spikes, negative consumption or
+// meter resets) in the statistics of a device before they are exported. Rules without a threshold
+// are disabled, except that negative energy is always flagged.
+type Validation struct {
+	Min               *float64 `json:"min,omitempty"`                 // Wh per bucket (default: 0)
+	Max               *float64 `json:"max,omitempty"`                 // Wh per bucket
+	ZScore            float64  `json:"z_score,omitempty"`             // standard deviations
+	Window            int      `json:"window,omitempty"`              // buckets for the z-score (default: 24)
+	ZeroStreak        int      `json:"zero_streak,omitempty"`         // buckets
+	PhaseSumTolerance float64  `json:"phase_sum_tolerance,omitempty"` // Wh per bucket
+	Action            string   `json:"action,omitempty"`              // annotate (default) or quarantine
+	FailOn            []string `json:"fail_on,omitempty"`             // rules failing the run
+}
+
+// Enabled returns whether the rule is enabled.
+func (v *Validation) Enabled(rule string) bool {
+	switch rule {
+	case RuleBounds:
+		return true
+	case RuleZScore:
+		return v.ZScore > 0
+	case RuleZeroStreak:
+		return v.ZeroStreak > 0
+	case RulePhaseSum:
+		return v.PhaseSumTolerance > 0
+	default:
+		return false
+	}
+}
+
+func validateValidation(v *Validation) error {
+	if v == nil {
+		return nil
+	}
+	if v.Min == nil {
+		v.Min = new(float64)
+	}
+	if v.Max != nil && *v.Max < *v.Min {
+		return errors.New("max cannot be less than min")
+	}
+	if v.ZScore < 0 || v.ZeroStreak < 0 || v.PhaseSumTolerance < 0 {
+		return errors.New("z_score, zero_streak and phase_sum_tolerance cannot be negative")
+	}
+	if v.Window < 0 {
+		return errors.New("window cannot be negative")
+	}
+	if v.Window == 0 {
+		v.Window = defaultZScoreWindow
+	}
+	switch v.Action {
+	case "":
+		v.Action = ActionAnnotate
+	case ActionAnnotate, ActionQuarantine:
+	default:
+		return fmt.Errorf("action %q is not supported", v.Action)
+	}
+	for _, rule := range v.FailOn {
+		if !slices.Contains(validationRules, rule) {
+			return fmt.Errorf("rule %q is not supported", rule)
+		}
+	}
+	return nil
+}
+
+// validationColumns adds the column listing the anomalies of each bucket to the columns of a
+// device which is validated if it is missing.
+func validationColumns(columns []string, v *Validation) []string {
+	if v == nil || slices.Contains(columns, ColumnAnomalies) {
+		return columns
+	}
+	return append(slices.Clone(columns), ColumnAnomalies)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// VirtualDeviceType is the device type of virtual devices unless set otherwise.
+const VirtualDeviceType = "em-1"
+
+var (
+	phaseNames = []string{"a", "b", "c"}
+)
+
+// Term is a part of the sum a virtual device is computed from, e.g. phase B of the main meter
+// (factor 1) or a sub-meter to subtract (factor -1).
+type Term struct {
+	Device  string  `json:"device"`            // name of the device
+	Phase   string  `json:"phase,omitempty"`   // a, b or c (default: total of all phases)
+	Channel *int    `json:"channel,omitempty"` // index of the channel (default: sum of all channels)
+	Factor  float64 `json:"factor,omitempty"`  // default: 1
+}
+
+// PhaseIndex returns the index of the phase of the term or -1 for the total of all phases.
+func (t *Term) PhaseIndex() int {
+	return slices.Index(phaseNames, t.Phase)
+}
+
+// validateVirtualDevice returns an error if the virtual device at index i has no terms or
+// references a device which does not exist, is disabled or is a virtual device defined later.
+func validateVirtualDevice(config *Config, i int) error {
+	dev := config.Devices[i]
+	if dev.Name == "" {
+		return errors.New("name needs to be set")
+	}
+	if dev.Type == "" {
+		dev.Type = VirtualDeviceType
+	}
+	if dev.Tariff != "" {
+		return errors.New("tariff cannot be set, the cost is computed from the referenced devices")
+	}
+	if len(dev.Terms) == 0 {
+		return errors.New("at least one term needs to be set")
+	}
+	for _, t := range dev.Terms {
+		if t.Phase != "" && t.PhaseIndex() < 0 {
+			return fmt.Errorf("phase %q of term for %q is not supported", t.Phase, t.Device)
+		}
+		if t.Channel != nil && *t.Channel < 0 {
+			return fmt.Errorf("channel of term for %q cannot be negative", t.Device)
+		}
+		if t.Factor == 0 {
+			t.Factor = 1
+		}
+		if err := validateReference(config, t.Device); err != nil {
+			return err
+		}
+		ref, _ := config.FindDevice(t.Device)
+		if ref == dev {
+			return errors.New("device cannot reference itself")
+		}
+		if ref.Source == SourceVirtual && slices.Index(config.Devices, ref) > i {
+			return fmt.Errorf("virtual device %q needs to be defined before it is referenced", t.Device)
+		}
+	}
+	return nil
+}
@@ -5,59 +5,45 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/finfinack/shellyExport/pkg/config"
 	"github.com/finfinack/shellyExport/pkg/shelly"
 )
 
-func ToCSV(stats *shelly.PowerConsumptionStatistics, w io.Writer) error {
-	writer := csv.NewWriter(w)
+// ToCSV writes the statistics of all channels of a device with the given columns (see
+// config.SupportedColumns) and format (optional) as CSV.
+func ToCSV(stats []*shelly.PowerConsumptionStatistics, columns []string, format *config.Format, w io.Writer) error {
+	t, err := newTable(stats, columns)
+	if err != nil {
+		return err
+	}
+	return writeCSV(t, format, w)
+}
 
-	switch stats.DeviceType.Phases {
-	case 1:
-		writer.Write([]string{
-			"day",
-			"total",
-			"total_returned",
-			"is_missing",
-		})
-		for i := 0; i < len(stats.Stats1p.History); i++ {
-			writer.Write([]string{
-				stats.Stats1p.History[i].DateTime.Format(dayFmt),
-				fmt.Sprintf("%f", stats.Stats1p.History[i].Consumption),
-				fmt.Sprintf("%f", stats.Stats1p.History[i].Reversed),
-				fmt.Sprintf("%t", stats.Stats1p.History[i].IsMissing),
-			})
-		}
-	case 3:
-		writer.Write([]string{
-			"day",
-			"phase_a",
-			"phase_b",
-			"phase_c",
-			"total",
-			"phase_a_returned",
-			"phase_b_returned",
-			"phase_c_returned",
-			"total_returned",
-			"is_missing",
-		})
-		for i := 0; i < len(stats.Stats3p.Sum); i++ {
-			writer.Write([]string{
-				stats.Stats3p.Sum[i].DateTime.Format(dayFmt),
-				fmt.Sprintf("%f", stats.Stats3p.History[0][i].Consumption),
-				fmt.Sprintf("%f", stats.Stats3p.History[1][i].Consumption),
-				fmt.Sprintf("%f", stats.Stats3p.History[2][i].Consumption),
-				fmt.Sprintf("%f", stats.Stats3p.Sum[i].Consumption),
-				fmt.Sprintf("%f", stats.Stats3p.History[0][i].Reversed),
-				fmt.Sprintf("%f", stats.Stats3p.History[1][i].Reversed),
-				fmt.Sprintf("%f", stats.Stats3p.History[2][i].Reversed),
-				fmt.Sprintf("%f", stats.Stats3p.Sum[i].Reversed),
-				fmt.Sprintf("%t", stats.Stats3p.Sum[i].IsMissing),
-			})
+func writeCSV(t *table, format *config.Format, w io.Writer) error {
+	t = t.format(format)
+	writer := csv.NewWriter(w)
+	writer.Write(t.header)
+	for _, r := range t.rows {
+		record := []string{r.label}
+		for _, v := range r.values {
+			record = append(record, formatCSV(v, format))
 		}
-	default:
-		return fmt.Errorf("unsupported amount of phases: %d", stats.DeviceType.Phases)
+		writer.Write(record)
 	}
 
 	writer.Flush()
-	return nil
+	return writer.Error()
+}
+
+func formatCSV(v interface{}, format *config.Format) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return formatFloat(v, format)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
 }
@@ -1,7 +1,66 @@
 package export
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
 
 const (
-	dayFmt = time.DateOnly
+	weekFmt  = "%04d-W%02d"
+	yearFmt  = "2006"
+	monthFmt = "2006-01"
+	dayFmt   = time.DateOnly
+	hourFmt  = time.RFC3339
 )
+
+// bucketFmts lists all formats buckets may be labelled with, used to parse existing labels. Labels
+// of hourly buckets used to be written without an offset.
+var bucketFmts = []string{hourFmt, time.DateTime, dayFmt, monthFmt, yearFmt}
+
+// bucketFmt returns the format used to label buckets of the given interval. Hourly buckets are
+// labelled with their start including the UTC offset, coarser buckets with their calendar date.
+func bucketFmt(interval string) string {
+	switch interval {
+	case config.IntervalHour:
+		return hourFmt
+	case config.IntervalMonth:
+		return monthFmt
+	case config.IntervalYear:
+		return yearFmt
+	default:
+		return dayFmt
+	}
+}
+
+// bucketLabel returns the label of the bucket of the given interval or rollup period starting at t.
+// Weeks are labelled with their ISO week (e.g. "2024-W09"), billing periods with their first day.
+func bucketLabel(interval string, t time.Time) string {
+	if interval == config.RollupWeek {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf(weekFmt, year, week)
+	}
+	return t.Format(bucketFmt(interval))
+}
+
+// parseBucket parses the label of a bucket written in any of the bucket formats. Labels without
+// an offset are interpreted in the given location.
+func parseBucket(label string, loc *time.Location) (time.Time, error) {
+	var year, week int
+	if _, err := fmt.Sscanf(label, weekFmt, &year, &week); err == nil {
+		// The 4th of January is always in the first ISO week.
+		jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, loc)
+		monday := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7)
+		return monday.AddDate(0, 0, (week-1)*7), nil
+	}
+
+	var err error
+	for _, layout := range bucketFmts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, label, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+// format returns a copy of the table with the energy converted into the unit of the format (which
+// is appended to the names of the energy columns) and all numbers rounded as configured.
+func (t *table) format(f *config.Format) *table {
+	if f == nil {
+		return t
+	}
+
+	out := &table{
+		interval: t.interval,
+		header:   append([]string{}, t.header...),
+		energy:   t.energy,
+	}
+	if f.Unit != "" {
+		for i, energy := range t.energy {
+			if energy {
+				out.header[i+1] = fmt.Sprintf("%s_%s", out.header[i+1], strings.ToLower(f.Unit))
+			}
+		}
+	}
+
+	for _, r := range t.rows {
+		values := make([]interface{}, len(r.values))
+		for i, v := range r.values {
+			if fv, ok := v.(float64); ok {
+				if i < len(t.energy) && t.energy[i] {
+					fv /= f.Divisor()
+				}
+				v = round(fv, f)
+			}
+			values[i] = v
+		}
+		out.rows = append(out.rows, &row{time: r.time, label: r.label, values: values})
+	}
+	return out
+}
+
+// round rounds v to the decimals of the format using its rounding mode.
+func round(v float64, f *config.Format) float64 {
+	if f.Decimals == nil {
+		return v
+	}
+	scale := math.Pow10(*f.Decimals)
+	scaled := v * scale
+	switch f.Rounding {
+	case config.RoundingHalfEven:
+		scaled = math.RoundToEven(scaled)
+	case config.RoundingDown:
+		scaled = math.Floor(scaled)
+	case config.RoundingUp:
+		scaled = math.Ceil(scaled)
+	default:
+		scaled = math.Round(scaled)
+	}
+	return scaled / scale
+}
+
+// formatFloat returns the number with the decimals of the format if set.
+func formatFloat(v float64, f *config.Format) string {
+	if f == nil || f.Decimals == nil {
+		return fmt.Sprintf("%f", v)
+	}
+	return fmt.Sprintf("%.*f", *f.Decimals, v)
+}
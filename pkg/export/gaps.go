@@ -0,0 +1,22 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/finfinack/shellyExport/pkg/gaps"
+)
+
+// GapsToCSV writes the gaps of the statistics of a device with buckets of the given interval as
+// CSV, one row per gap of each channel and phase.
+func GapsToCSV(gs []*gaps.Gap, interval string, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"channel", "phase", "from", "to", "buckets"})
+	for _, g := range gs {
+		writer.Write([]string{g.Channel, g.Series, bucketLabel(interval, g.From), bucketLabel(interval, g.To), fmt.Sprintf("%d", g.Buckets)})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,63 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+// Report is a table of values per bucket which is derived from the statistics of several devices,
+// e.g. the analysis of a site.
+type Report struct {
+	Interval string
+	Location *time.Location
+	Columns  []string
+	Energy   []bool                      // whether each column holds energy in Wh
+	Rows     map[time.Time][]interface{} // keyed by the start of each bucket
+}
+
+func (r *Report) table() (*table, error) {
+	if len(r.Rows) == 0 {
+		return nil, errors.New("no rows to export")
+	}
+	t := &table{
+		interval: r.Interval,
+		header:   append([]string{r.Interval}, r.Columns...),
+		energy:   r.Energy,
+	}
+	for ts, values := range r.Rows {
+		ts = ts.In(r.Location)
+		t.rows = append(t.rows, &row{time: ts, label: bucketLabel(r.Interval, ts), values: values})
+	}
+	sort.Slice(t.rows, func(i, j int) bool {
+		return t.rows[i].time.Before(t.rows[j].time)
+	})
+	return t, nil
+}
+
+// ReportToCSV writes the report with the given format (optional) as CSV.
+func ReportToCSV(r *Report, format *config.Format, w io.Writer) error {
+	t, err := r.table()
+	if err != nil {
+		return err
+	}
+	return writeCSV(t, format, w)
+}
+
+// ReportToGoogleSheet writes the report to the sheet in the same way as the statistics of a
+// device.
+func ReportToGoogleSheet(ctx context.Context, r *Report, cfg *config.GoogleSheet) error {
+	t, err := r.table()
+	if err != nil {
+		return err
+	}
+	svc, err := newSheetsService(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return trixExport(ctx, cfg, svc, t.format(cfg.Format))
+}
@@ -0,0 +1,136 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// counted maps the suffix of the columns flagging buckets to the name of the column counting them
+// in a rollup.
+var counted = map[string]string{
+	config.ColumnIsMissing:   "missing",
+	config.ColumnIsEstimated: "estimated",
+}
+
+// periodStart returns the start of the rollup period which t falls into.
+func periodStart(r *config.Rollup, t time.Time) time.Time {
+	switch r.Period {
+	case config.RollupWeek:
+		monday := t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+		return time.Date(monday.Year(), monday.Month(), monday.Day(), 0, 0, 0, 0, t.Location())
+	case config.RollupMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case config.RollupYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	default: // billing
+		if t.Day() < r.BillingStartDay {
+			t = t.AddDate(0, -1, 0)
+		}
+		return time.Date(t.Year(), t.Month(), r.BillingStartDay, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// rollupFields returns the fields which can be summed up over a rollup period as well as whether
+// buckets are missing or estimated, which is counted instead.
+func rollupFields(fields []string) []string {
+	additive := []string{}
+	for _, field := range fields {
+		if counted[field] != "" || config.IsAdditiveColumn(field) {
+			additive = append(additive, field)
+		}
+	}
+	return additive
+}
+
+// rollup returns a table with the rows of the table summed up per rollup period. Columns telling
+// whether a bucket is missing or estimated are replaced by the number of such buckets.
+func (t *table) rollup(r *config.Rollup) *table {
+	out := &table{
+		interval: r.Period,
+		header:   []string{r.Period},
+		energy:   t.energy,
+	}
+	for _, col := range t.header[1:] {
+		for flag, count := range counted {
+			if strings.HasSuffix(col, flag) {
+				col = strings.TrimSuffix(col, flag) + count
+			}
+		}
+		out.header = append(out.header, col)
+	}
+
+	periods := map[time.Time]*row{}
+	for _, tr := range t.rows {
+		start := periodStart(r, tr.time)
+		pr, ok := periods[start]
+		if !ok {
+			pr = &row{time: start, label: bucketLabel(r.Period, start), values: make([]interface{}, len(tr.values))}
+			periods[start] = pr
+		}
+		for i, v := range tr.values {
+			switch v := v.(type) {
+			case float64:
+				sum, _ := pr.values[i].(float64)
+				pr.values[i] = sum + v
+			case bool:
+				count, _ := pr.values[i].(int)
+				if v {
+					count++
+				}
+				pr.values[i] = count
+			}
+		}
+	}
+	for _, pr := range periods {
+		out.rows = append(out.rows, pr)
+	}
+	sort.Slice(out.rows, func(i, j int) bool {
+		return out.rows[i].time.Before(out.rows[j].time)
+	})
+	return out
+}
+
+func newRollupTable(stats []*shelly.PowerConsumptionStatistics, columns []string, r *config.Rollup) (*table, error) {
+	t, err := newTable(stats, rollupFields(columns))
+	if err != nil {
+		return nil, err
+	}
+	return t.rollup(r), nil
+}
+
+// RollupToCSV writes the statistics of all channels of a device summed up per rollup period as CSV.
+// Only the columns which can be summed up are written, whether buckets are missing is counted.
+func RollupToCSV(stats []*shelly.PowerConsumptionStatistics, columns []string, r *config.Rollup, format *config.Format, w io.Writer) error {
+	t, err := newRollupTable(stats, columns, r)
+	if err != nil {
+		return err
+	}
+	return writeCSV(t, format, w)
+}
+
+// RollupToGoogleSheet writes the statistics of all channels of a device summed up per rollup period
+// to a separate tab named after the sheet of the device and the rollup (e.g. "Home week"), which is
+// created if it does not exist yet.
+func RollupToGoogleSheet(ctx context.Context, stats []*shelly.PowerConsumptionStatistics, columns []string, r *config.Rollup, cfg *config.GoogleSheet) error {
+	t, err := newRollupTable(stats, columns, r)
+	if err != nil {
+		return err
+	}
+	svc, err := newSheetsService(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	rollupCfg := *cfg
+	rollupCfg.SheetID = fmt.Sprintf("%s %s", cfg.SheetID, r.Name())
+	if err := ensureSheet(ctx, svc, rollupCfg.SpreadsheetID, rollupCfg.SheetID); err != nil {
+		return err
+	}
+	return trixExport(ctx, &rollupCfg, svc, t.format(cfg.Format))
+}
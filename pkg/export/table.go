@@ -0,0 +1,91 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// table is the tabular representation of the statistics of all channels of a device. Channels are
+// exported side by side with one row per bucket.
+type table struct {
+	interval string
+	header   []string
+	energy   []bool // whether each value column holds energy in Wh
+	rows     []*row
+}
+
+type row struct {
+	time   time.Time
+	label  string
+	values []interface{}
+}
+
+// channelColumns returns the column names and the values per bucket of a single channel for the
+// selected fields. The buckets are keyed by their start in UTC as channels may use different
+// location instances.
+func channelColumns(stats *shelly.PowerConsumptionStatistics, fields []string) ([]*shelly.Column, map[time.Time][]interface{}) {
+	values := map[time.Time][]interface{}{}
+	for ts, v := range stats.Stats.Rows(fields) {
+		values[ts.UTC()] = v
+	}
+	return stats.Stats.Columns(fields), values
+}
+
+// newTable builds the table for the statistics of all channels of a device. If there is more than
+// one channel, the columns are prefixed with the channel name. Buckets missing for a channel are
+// left empty.
+func newTable(stats []*shelly.PowerConsumptionStatistics, fields []string) (*table, error) {
+	if len(stats) == 0 {
+		return nil, errors.New("no statistics to export")
+	}
+
+	t := &table{
+		interval: stats[0].Stats.Interval,
+		header:   []string{stats[0].Stats.Interval},
+	}
+	loc := stats[0].Stats.Location()
+	values := []map[time.Time][]interface{}{}
+	widths := []int{}
+	times := map[time.Time]bool{}
+	for _, s := range stats {
+		if iv := s.Stats.Interval; iv != t.interval {
+			return nil, fmt.Errorf("interval of channel %q (%q) is different from the others (%q)", s.Channel.Name(), iv, t.interval)
+		}
+		cols, vals := channelColumns(s, fields)
+		for _, col := range cols {
+			name := col.Name
+			if len(stats) > 1 {
+				name = fmt.Sprintf("%s_%s", s.Channel.Name(), name)
+			}
+			t.header = append(t.header, name)
+			t.energy = append(t.energy, config.IsEnergyColumn(col.Field))
+		}
+		for ts := range vals {
+			times[ts] = true
+		}
+		values = append(values, vals)
+		widths = append(widths, len(cols))
+	}
+
+	for ts := range times {
+		r := &row{time: ts.In(loc), label: bucketLabel(t.interval, ts.In(loc))}
+		for i, vals := range values {
+			v, ok := vals[ts]
+			if !ok {
+				v = make([]interface{}, widths[i])
+			}
+			r.values = append(r.values, v...)
+		}
+		t.rows = append(t.rows, r)
+	}
+	sort.Slice(t.rows, func(i, j int) bool {
+		return t.rows[i].time.Before(t.rows[j].time)
+	})
+
+	return t, nil
+}
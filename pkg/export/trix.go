@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"time"
 
 	"github.com/finfinack/shellyExport/pkg/config"
 	"github.com/finfinack/shellyExport/pkg/shelly"
@@ -19,46 +18,53 @@ const (
 	insertDataOptionInsertRows  = "INSERT_ROWS"  // https://developers.google.com/sheets/api/reference/rest/v4/spreadsheets.values/append#InsertDataOption
 )
 
-func ToGoogleSheet(ctx context.Context, stats *shelly.PowerConsumptionStatistics, cfg *config.GoogleSheet) error {
+func ToGoogleSheet(ctx context.Context, stats []*shelly.PowerConsumptionStatistics, columns []string, cfg *config.GoogleSheet) error {
+	t, err := newTable(stats, columns)
+	if err != nil {
+		return err
+	}
+	svc, err := newSheetsService(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return trixExport(ctx, cfg, svc, t.format(cfg.Format))
+}
+
+// newSheetsService returns a Sheets service authenticated with the service account key.
+func newSheetsService(ctx context.Context, cfg *config.GoogleSheet) (*sheets.Service, error) {
 	creds, err := base64.StdEncoding.DecodeString(cfg.SvcAcctKey)
 	if err != nil {
-		return fmt.Errorf("unable to decode service account key: %s", err)
+		return nil, fmt.Errorf("unable to decode service account key: %s", err)
 	}
 
 	config, err := google.JWTConfigFromJSON(creds, sheets.SpreadsheetsScope)
 	if err != nil {
-		return fmt.Errorf("unable to create JWT config from JSON: %s", err)
+		return nil, fmt.Errorf("unable to create JWT config from JSON: %s", err)
 	}
 
 	client := config.Client(ctx)
 	svc, err := sheets.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		return fmt.Errorf("unable to create new service: %s", err)
+		return nil, fmt.Errorf("unable to create new service: %s", err)
 	}
+	return svc, nil
+}
 
-	switch stats.DeviceType.Phases {
-	case 1:
-		return trixExport1p(ctx, cfg, svc, stats.Stats1p)
-	case 3:
-		return trixExport3p(ctx, cfg, svc, stats.Stats3p)
-	default:
-		return fmt.Errorf("unsupported amount of phases: %d", stats.DeviceType.Phases)
+func trixExport(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Service, t *table) error {
+	if len(t.rows) == 0 {
+		return nil
 	}
-}
+	lastCol := columnName(len(t.header))
 
-func trixExport1p(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Service, stats *shelly.PowerConsumptionStatistics1p) error {
 	// Overwrite headers
+	header := []interface{}{"date"}
+	for _, col := range t.header[1:] {
+		header = append(header, col)
+	}
 	values := &sheets.ValueRange{
-		Values: [][]interface{}{
-			{
-				"date",
-				"total",
-				"total_returned",
-				"is_missing",
-			},
-		},
+		Values: [][]interface{}{header},
 	}
-	hdrResp, err := svc.Spreadsheets.Values.Update(cfg.SpreadsheetID, fmt.Sprintf("%s!A1:J1", cfg.SheetID), values).ValueInputOption(valueInputOptionUserEntered).Context(ctx).Do()
+	hdrResp, err := svc.Spreadsheets.Values.Update(cfg.SpreadsheetID, fmt.Sprintf("%s!A1:%s1", cfg.SheetID, lastCol), values).ValueInputOption(valueInputOptionUserEntered).Context(ctx).Do()
 	if err != nil {
 		return fmt.Errorf("unable to update header values: %s", err)
 	}
@@ -67,13 +73,13 @@ func trixExport1p(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Serv
 	}
 
 	// Figure out whether there is overlap.
-	firstDate := time.Time(stats.History[0].DateTime)
+	firstDate := t.rows[0].time
 	getResp, err := svc.Spreadsheets.Values.Get(cfg.SpreadsheetID, fmt.Sprintf("%s!A:A", cfg.SheetID)).Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("unable to update header values: %s", err)
+		return fmt.Errorf("unable to get existing values: %s", err)
 	}
-	if hdrResp.HTTPStatusCode != 200 {
-		return fmt.Errorf("unable to update header values: HTTP code %d", hdrResp.HTTPStatusCode)
+	if getResp.HTTPStatusCode != 200 {
+		return fmt.Errorf("unable to get existing values: HTTP code %d", getResp.HTTPStatusCode)
 	}
 	rowIdx := 1
 	for _, row := range getResp.Values {
@@ -81,15 +87,12 @@ func trixExport1p(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Serv
 			rowIdx += 1
 			continue // skip header
 		}
-		if row[0].(string) == "" {
+		if len(row) == 0 || row[0].(string) == "" {
 			break
 		}
-		rowDate, err := time.Parse(time.DateTime, row[0].(string))
+		rowDate, err := parseBucket(row[0].(string), firstDate.Location())
 		if err != nil {
-			rowDate, err = time.Parse(dayFmt, row[0].(string))
-			if err != nil {
-				return fmt.Errorf("unable to parse row as date/time: %s", row[0])
-			}
+			return fmt.Errorf("unable to parse row as date/time: %s", row[0])
 		}
 		if rowDate.Before(firstDate) {
 			rowIdx += 1
@@ -99,16 +102,11 @@ func trixExport1p(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Serv
 	}
 
 	values = &sheets.ValueRange{Values: [][]interface{}{}}
-	for i := 0; i < len(stats.History); i++ {
-		values.Values = append(values.Values, []interface{}{
-			stats.History[i].DateTime.Format(dayFmt),
-			stats.History[i].Consumption,
-			stats.History[i].Reversed,
-			stats.History[i].IsMissing,
-		})
+	for _, r := range t.rows {
+		values.Values = append(values.Values, append([]interface{}{r.label}, sheetValues(r.values)...))
 	}
 
-	upResp, err := svc.Spreadsheets.Values.Update(cfg.SpreadsheetID, fmt.Sprintf("%s!A%d:J%d", cfg.SheetID, rowIdx, rowIdx+len(stats.History)), values).ValueInputOption(valueInputOptionUserEntered).Context(ctx).Do()
+	upResp, err := svc.Spreadsheets.Values.Update(cfg.SpreadsheetID, fmt.Sprintf("%s!A%d:%s%d", cfg.SheetID, rowIdx, lastCol, rowIdx+len(t.rows)), values).ValueInputOption(valueInputOptionUserEntered).Context(ctx).Do()
 	if err != nil {
 		return fmt.Errorf("unable to update values: %s", err)
 	}
@@ -119,87 +117,50 @@ func trixExport1p(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Serv
 	return nil
 }
 
-func trixExport3p(ctx context.Context, cfg *config.GoogleSheet, svc *sheets.Service, stats *shelly.PowerConsumptionStatistics3p) error {
-	// Overwrite headers
-	values := &sheets.ValueRange{
-		Values: [][]interface{}{
-			{
-				"date",
-				"phase_a",
-				"phase_b",
-				"phase_c",
-				"total",
-				"phase_a_returned",
-				"phase_b_returned",
-				"phase_c_returned",
-				"total_returned",
-				"is_missing",
-			},
-		},
-	}
-	hdrResp, err := svc.Spreadsheets.Values.Update(cfg.SpreadsheetID, fmt.Sprintf("%s!A1:J1", cfg.SheetID), values).ValueInputOption(valueInputOptionUserEntered).Context(ctx).Do()
-	if err != nil {
-		return fmt.Errorf("unable to update header values: %s", err)
+// sheetValues replaces values missing for a channel with empty cells.
+func sheetValues(values []interface{}) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		if v == nil {
+			v = ""
+		}
+		out[i] = v
 	}
-	if hdrResp.HTTPStatusCode != 200 {
-		return fmt.Errorf("unable to update header values: HTTP code %d", hdrResp.HTTPStatusCode)
+	return out
+}
+
+// columnName returns the name of the n-th (1-based) column in a sheet, e.g. "A", "Z" or "AA".
+func columnName(n int) string {
+	name := ""
+	for n > 0 {
+		n--
+		name = string(rune('A'+n%26)) + name
+		n /= 26
 	}
+	return name
+}
 
-	// Figure out whether there is overlap.
-	firstDate := time.Time(stats.Sum[0].DateTime)
-	getResp, err := svc.Spreadsheets.Values.Get(cfg.SpreadsheetID, fmt.Sprintf("%s!A:A", cfg.SheetID)).Context(ctx).Do()
+// ensureSheet adds a sheet (tab) with the given title to the spreadsheet if there is none yet.
+func ensureSheet(ctx context.Context, svc *sheets.Service, spreadsheetID, title string) error {
+	spreadsheet, err := svc.Spreadsheets.Get(spreadsheetID).Fields("sheets.properties.title").Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("unable to update header values: %s", err)
+		return fmt.Errorf("unable to get sheets: %s", err)
 	}
-	if hdrResp.HTTPStatusCode != 200 {
-		return fmt.Errorf("unable to update header values: HTTP code %d", hdrResp.HTTPStatusCode)
-	}
-	rowIdx := 1
-	for _, row := range getResp.Values {
-		if rowIdx == 1 {
-			rowIdx += 1
-			continue // skip header
-		}
-		if row[0].(string) == "" {
-			break
-		}
-		rowDate, err := time.Parse(time.DateTime, row[0].(string))
-		if err != nil {
-			rowDate, err = time.Parse(dayFmt, row[0].(string))
-			if err != nil {
-				return fmt.Errorf("unable to parse row as date/time: %s", row[0])
-			}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == title {
+			return nil
 		}
-		if rowDate.Before(firstDate) {
-			rowIdx += 1
-			continue
-		}
-		break
 	}
 
-	values = &sheets.ValueRange{Values: [][]interface{}{}}
-	for i := 0; i < len(stats.Sum); i++ {
-		values.Values = append(values.Values, []interface{}{
-			stats.Sum[i].DateTime.Format(dayFmt),
-			stats.History[0][i].Consumption,
-			stats.History[1][i].Consumption,
-			stats.History[2][i].Consumption,
-			stats.Sum[i].Consumption,
-			stats.History[0][i].Reversed,
-			stats.History[1][i].Reversed,
-			stats.History[2][i].Reversed,
-			stats.Sum[i].Reversed,
-			stats.Sum[i].IsMissing,
-		})
-	}
-
-	upResp, err := svc.Spreadsheets.Values.Update(cfg.SpreadsheetID, fmt.Sprintf("%s!A%d:J%d", cfg.SheetID, rowIdx, rowIdx+len(stats.Sum)), values).ValueInputOption(valueInputOptionUserEntered).Context(ctx).Do()
-	if err != nil {
-		return fmt.Errorf("unable to update values: %s", err)
+	req := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			AddSheet: &sheets.AddSheetRequest{
+				Properties: &sheets.SheetProperties{Title: title},
+			},
+		}},
 	}
-	if upResp.HTTPStatusCode != 200 {
-		return fmt.Errorf("unable to update values: HTTP code %d", upResp.HTTPStatusCode)
+	if _, err := svc.Spreadsheets.BatchUpdate(spreadsheetID, req).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("unable to add sheet %q: %s", title, err)
 	}
-
 	return nil
 }
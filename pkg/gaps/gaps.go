@@ -0,0 +1,158 @@
+// Package gaps finds buckets for which the statistics of a device are missing and fills them with
+// estimates.
+package gaps
+
+import (
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// Gap is a run of consecutive missing buckets of a phase (or the total) of a channel.
+type Gap struct {
+	Channel string
+	Series  string    // name of the phase or "total"
+	From    time.Time // start of the first missing bucket
+	To      time.Time // start of the last missing bucket
+	Buckets int
+}
+
+// Find returns the gaps of each phase and the total of the statistics. The statistics need to be
+// completed first so that absent buckets are marked as missing.
+func Find(stats *shelly.PowerConsumptionStatistics) []*Gap {
+	gaps := []*Gap{}
+	names := stats.Stats.SeriesNames()
+	for i, entries := range stats.Stats.Entries() {
+		var gap *Gap
+		for _, e := range entries {
+			if !e.IsMissing {
+				gap = nil
+				continue
+			}
+			if gap == nil {
+				gap = &Gap{Channel: stats.Channel.Name(), Series: names[i], From: time.Time(e.DateTime)}
+				gaps = append(gaps, gap)
+			}
+			gap.To = time.Time(e.DateTime)
+			gap.Buckets++
+		}
+	}
+	return gaps
+}
+
+// Fill estimates the consumed and returned energy of the buckets without any data using the given
+// strategy (see config.FillEmpty and the like) and flags them as estimated. Buckets which cannot
+// be estimated (e.g. without data before and after a gap for linear interpolation) are left empty.
+// It returns the number of estimated entries.
+func Fill(stats *shelly.Series, strategy string) int {
+	filled := 0
+	for _, entries := range stats.Entries() {
+		switch strategy {
+		case config.FillZero:
+			filled += fillZero(entries)
+		case config.FillLinear:
+			filled += fillLinear(entries)
+		case config.FillWeekday:
+			filled += fillWeekday(entries, stats.Interval)
+		}
+	}
+	return filled
+}
+
+// hasNoData returns whether the entry has no data to be kept, i.e. it is absent or marked as
+// missing without any energy.
+func hasNoData(e *shelly.Entry) bool {
+	return e.IsAbsent || (e.IsMissing && e.Consumption == 0 && e.Reversed == 0)
+}
+
+func estimate(e *shelly.Entry, consumption, reversed float64) {
+	e.Consumption = consumption
+	e.Reversed = reversed
+	e.IsEstimated = true
+}
+
+func fillZero(entries []*shelly.Entry) int {
+	filled := 0
+	for _, e := range entries {
+		if hasNoData(e) {
+			estimate(e, 0, 0)
+			filled++
+		}
+	}
+	return filled
+}
+
+// fillLinear interpolates linearly between the last bucket with data before and the first one
+// after each gap.
+func fillLinear(entries []*shelly.Entry) int {
+	filled := 0
+	for start := 0; start < len(entries); start++ {
+		if !hasNoData(entries[start]) {
+			continue
+		}
+		end := start
+		for end < len(entries) && hasNoData(entries[end]) {
+			end++
+		}
+		if start > 0 && end < len(entries) {
+			before, after := entries[start-1], entries[end]
+			steps := float64(end - start + 1)
+			for i := start; i < end; i++ {
+				k := float64(i-start+1) / steps
+				estimate(entries[i], before.Consumption+k*(after.Consumption-before.Consumption), before.Reversed+k*(after.Reversed-before.Reversed))
+				filled++
+			}
+		}
+		start = end
+	}
+	return filled
+}
+
+// fillWeekday uses the average of all buckets with data on the same weekday (and at the same hour
+// for hourly buckets).
+func fillWeekday(entries []*shelly.Entry, interval string) int {
+	type slot struct {
+		weekday time.Weekday
+		hour    int
+	}
+	slotOf := func(e *shelly.Entry) slot {
+		t := time.Time(e.DateTime)
+		s := slot{weekday: t.Weekday()}
+		if interval == config.IntervalHour {
+			s.hour = t.Hour()
+		}
+		return s
+	}
+
+	type average struct {
+		consumption, reversed float64
+		n                     int
+	}
+	averages := map[slot]*average{}
+	for _, e := range entries {
+		if hasNoData(e) {
+			continue
+		}
+		a, ok := averages[slotOf(e)]
+		if !ok {
+			a = &average{}
+			averages[slotOf(e)] = a
+		}
+		a.consumption += e.Consumption
+		a.reversed += e.Reversed
+		a.n++
+	}
+
+	filled := 0
+	for _, e := range entries {
+		if !hasNoData(e) {
+			continue
+		}
+		if a, ok := averages[slotOf(e)]; ok {
+			estimate(e, a.consumption/float64(a.n), a.reversed/float64(a.n))
+			filled++
+		}
+	}
+	return filled
+}
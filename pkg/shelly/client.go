@@ -0,0 +1,334 @@
+package shelly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+const (
+	powerConsumptionPath = "/v2/statistics/power-consumption"
+	deviceListPath       = "/interface/device/get_all_lists"
+
+	// The cloud switches to hourly statistics for timeframes shorter than this.
+	minDailyTimeframe = 5 * 24 * time.Hour // 5 days
+)
+
+// Client talks to the Shelly cloud API.
+type Client struct {
+	baseURL    *url.URL
+	authKey    string
+	userAgent  string
+	httpClient *http.Client
+	timeout    time.Duration
+	limiter    *rateLimiter
+
+	maxRetries   int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+
+	logger *log.Logger
+}
+
+// ClientOption configures optional settings of a Client.
+type ClientOption func(*Client)
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to send requests (default: http.DefaultClient).
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets a timeout applied to each individual request (default: none).
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRateLimit limits the requests sent by the client to the given number per second (default:
+// unlimited). The limit is shared by all requests of the client, i.e. of the account.
+func WithRateLimit(requestsPerSecond float64) ClientOption {
+	return func(c *Client) {
+		if requestsPerSecond > 0 {
+			c.limiter = newRateLimiter(requestsPerSecond)
+		}
+	}
+}
+
+// WithRetry retries failed requests which are throttled (HTTP 429), hit a server error (HTTP 5xx)
+// or fail due to network errors up to maxRetries times (default: no retries). The delay between
+// attempts starts at initialDelay and doubles with every attempt up to maxDelay, with jitter
+// applied. A Retry-After header sent by the server takes precedence.
+func WithRetry(maxRetries int, initialDelay, maxDelay time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.initialDelay = initialDelay
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithLogger logs requests and retries to the given logger (default: nothing is logged).
+func WithLogger(logger *log.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// NewClient returns a client for the Shelly cloud server with the given base URL and auth key.
+func NewClient(server, authKey string, opts ...ClientOption) (*Client, error) {
+	baseURL, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server %q: %s", server, err)
+	}
+	c := &Client{
+		baseURL:    baseURL,
+		authKey:    authKey,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// PowerConsumption returns the power consumption statistics of the device channel in the given
+// timeframe aggregated per interval ("hour", "day", "month" or "year"). The cloud derives the
+// interval from the length of the timeframe, so hourly statistics should be requested for at most
+// a day at a time. For daily statistics, short timeframes are extended into the past when
+// requesting them and the entries before from are dropped again. If the cloud returns a finer
+// interval than requested (e.g. days for a year), the entries are kept as is. Use Normalize to roll
+// them up into the requested interval and Clip to restrict them to the requested timeframe.
+//
+// The timeframe is passed to the cloud as wall clock times which it interprets in the timezone of
+// the device. The returned entries are localized into the timezone configured for the device or,
+// if there is none, the one reported by the cloud.
+func (c *Client) PowerConsumption(ctx context.Context, dev *Device, channel int, interval string, from, to time.Time) (*PowerConsumptionStatistics, error) {
+	devType, ok := LookupDeviceType(dev.Type)
+	if !ok {
+		return nil, fmt.Errorf("device type %q is not supported", dev.Type)
+	}
+
+	requested := from
+	if interval == config.IntervalDay && to.Sub(from) < minDailyTimeframe {
+		from = to.Add(-minDailyTimeframe)
+	}
+
+	q := url.Values{}
+	q.Set("id", dev.ID)
+	q.Set("channel", strconv.Itoa(channel))
+	q.Set("date_range", "custom")
+	q.Set("date_from", from.Format(DateTimeFmt))
+	q.Set("date_to", to.Format(DateTimeFmt))
+
+	logf(c.logger, "requesting stats for device %q (ID %s, channel %d) from %q to %q\n", dev.Name, dev.ID, channel, from.Format(DateTimeFmt), to.Format(DateTimeFmt))
+
+	body, err := c.get(ctx, c.baseURL.JoinPath(devType.CloudPath()), q)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := devType.Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	if !isFinerOrEqual(stats.Interval, interval) {
+		return nil, fmt.Errorf("returned interval %q does not match requested interval %q", stats.Interval, interval)
+	}
+	loc, err := resolveLocation(dev.Timezone, stats.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	stats.Localize(loc)
+	if from.Before(requested) {
+		// The extension is only needed to get daily statistics from the cloud.
+		stats.dropBefore(requested)
+	}
+	return &PowerConsumptionStatistics{DeviceType: devType, Channel: &config.Channel{Index: channel}, Stats: stats}, nil
+}
+
+// DeviceInfo describes a device registered with the Shelly cloud account.
+type DeviceInfo struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Model string `json:"type"`
+}
+
+type deviceList struct {
+	IsOK   bool            `json:"isok"`
+	Errors json.RawMessage `json:"errors"`
+	Data   struct {
+		Devices map[string]*DeviceInfo `json:"devices"`
+	} `json:"data"`
+}
+
+// Devices returns all devices registered with the account, sorted by ID.
+func (c *Client) Devices(ctx context.Context) ([]*DeviceInfo, error) {
+	logf(c.logger, "requesting device list\n")
+
+	body, err := c.get(ctx, c.baseURL.JoinPath(deviceListPath), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	list := &deviceList{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return nil, fmt.Errorf("unable to parse body as JSON: %s", err)
+	}
+	if !list.IsOK {
+		return nil, fmt.Errorf("unable to list devices: %s", list.Errors)
+	}
+
+	devices := []*DeviceInfo{}
+	for id, dev := range list.Data.Devices {
+		if dev.ID == "" {
+			dev.ID = id
+		}
+		devices = append(devices, dev)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+	return devices, nil
+}
+
+// get sends an authenticated GET request to the given URL and returns the response body. Requests
+// are rate limited and retried according to the client's settings.
+func (c *Client) get(ctx context.Context, u *url.URL, q url.Values) ([]byte, error) {
+	q.Set("auth_key", c.authKey)
+	u.RawQuery = q.Encode()
+
+	for attempt := 0; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		body, retryAfter, err := c.do(ctx, u)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, errPermanent) || attempt >= c.maxRetries {
+			return nil, err
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		logf(c.logger, "request failed (attempt %d of %d), retrying in %s: %s\n", attempt+1, c.maxRetries+1, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// errPermanent marks errors for which retrying the request is pointless.
+var errPermanent = errors.New("permanent error")
+
+// do sends a single GET request. It returns the response body or an error along with the delay
+// requested by the server through the Retry-After header (if any).
+func (c *Client) do(ctx context.Context, u *url.URL) ([]byte, time.Duration, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: unable to create request: %s", errPermanent, redactURL(err, u))
+	}
+	if c.userAgent != "" {
+		req.Header.Add("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to fetch data: %s", redactURL(err, u))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to read response body: %s", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return body, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("unable to fetch data (response code %d): %s", resp.StatusCode, body)
+	default:
+		return nil, 0, fmt.Errorf("%w: unable to fetch data (response code %d): %s", errPermanent, resp.StatusCode, body)
+	}
+}
+
+// redactURL replaces the URL in errors returned by the HTTP client with one without the query,
+// which carries the auth key, so that the errors can be logged.
+func redactURL(err error, u *url.URL) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		redacted := *u
+		redacted.RawQuery = ""
+		urlErr.URL = redacted.String()
+	}
+	return err
+}
+
+// backoff returns the exponential backoff delay with full jitter for the given (0-based) attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.initialDelay
+	for i := 0; i < attempt && (c.maxDelay <= 0 || delay < c.maxDelay); i++ {
+		delay *= 2
+	}
+	if c.maxDelay > 0 && delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds
+// or an HTTP date. It returns 0 if the value is empty or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package shelly
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetRedactsAuthKey(t *testing.T) {
+	// A closed server makes every request fail with a network error.
+	srv := httptest.NewServer(nil)
+	srv.Close()
+
+	c, err := NewClient(srv.URL, "secret-key", WithRetry(1, 0, 0))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %s", err)
+	}
+	_, err = c.get(context.Background(), c.baseURL.JoinPath(deviceListPath), url.Values{})
+	if err == nil {
+		t.Fatalf("get() succeeded, want error")
+	}
+	if strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("get() returned error containing the auth key: %s", err)
+	}
+	if !strings.Contains(err.Error(), deviceListPath) {
+		t.Errorf("get() returned error without the path: %s", err)
+	}
+}
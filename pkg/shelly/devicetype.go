@@ -0,0 +1,72 @@
+package shelly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+// DeviceType describes a kind of Shelly device: where its statistics are found and how they are
+// decoded. Device types register themselves with RegisterDeviceType, usually in the init function
+// of their own file.
+type DeviceType interface {
+	// Name returns the name used to refer to the device type in the config, e.g. "em-3p".
+	Name() string
+	// Models returns the model codes reported by the Shelly cloud for this device type.
+	Models() []string
+	// CloudPath returns the path of the cloud endpoint providing the statistics.
+	CloudPath() string
+	// Phases returns the number of phases measured per channel.
+	Phases() int
+	// Channels returns the channels exported if none are configured for a device.
+	Channels() []*config.Channel
+	// LocalHistory reports whether the devices store an energy history which can be read locally.
+	LocalHistory() bool
+	// Decode decodes the statistics returned by the cloud endpoint.
+	Decode(body []byte) (*Series, error)
+}
+
+var deviceTypes = map[string]DeviceType{}
+
+// RegisterDeviceType makes the device type available under its name. It panics if a device type
+// with the same name is already registered.
+func RegisterDeviceType(devType DeviceType) {
+	name := strings.ToLower(devType.Name())
+	if _, ok := deviceTypes[name]; ok {
+		panic(fmt.Sprintf("device type %q is already registered", name))
+	}
+	deviceTypes[name] = devType
+}
+
+// LookupDeviceType returns the registered device type with the given name.
+func LookupDeviceType(name string) (DeviceType, bool) {
+	devType, ok := deviceTypes[strings.ToLower(name)]
+	return devType, ok
+}
+
+// DeviceTypes returns all registered device types sorted by name.
+func DeviceTypes() []DeviceType {
+	types := []DeviceType{}
+	for _, devType := range deviceTypes {
+		types = append(types, devType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Name() < types[j].Name()
+	})
+	return types
+}
+
+// DeviceTypeForModel returns the registered device type of the model code reported by the Shelly
+// cloud (e.g. "SPEM-003CEBEU").
+func DeviceTypeForModel(model string) (DeviceType, bool) {
+	for _, devType := range DeviceTypes() {
+		for _, m := range devType.Models() {
+			if strings.EqualFold(m, model) {
+				return devType, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,98 @@
+package shelly
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// digestAuthorization returns the value of the Authorization header answering the digest
+// challenge sent by a device in the WWW-Authenticate header (RFC 7616). Gen2+ devices use
+// SHA-256 while older firmware might still use MD5.
+func digestAuthorization(challenge, method, uri, username, password string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	p := parseAuthParams(params)
+
+	var newHash func() hash.Hash
+	switch strings.ToUpper(p["algorithm"]) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", p["algorithm"])
+	}
+	h := func(parts ...string) string {
+		d := newHash()
+		d.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	ha1 := h(username, p["realm"], password)
+	ha2 := h(method, uri)
+	fields := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", p["realm"]),
+		fmt.Sprintf("nonce=%q", p["nonce"]),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if p["algorithm"] != "" {
+		fields = append(fields, fmt.Sprintf("algorithm=%s", p["algorithm"]))
+	}
+	if p["qop"] == "" {
+		fields = append(fields, fmt.Sprintf("response=%q", h(ha1, p["nonce"], ha2)))
+	} else {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("unable to create client nonce: %s", err)
+		}
+		cnonce := hex.EncodeToString(b)
+		nc := "00000001"
+		fields = append(fields,
+			fmt.Sprintf("response=%q", h(ha1, p["nonce"], nc, cnonce, "auth", ha2)),
+			"qop=auth",
+			fmt.Sprintf("nc=%s", nc),
+			fmt.Sprintf("cnonce=%q", cnonce),
+		)
+	}
+	if p["opaque"] != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", p["opaque"]))
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// parseAuthParams parses the comma separated key=value pairs of an authentication challenge.
+// Values may be quoted and contain commas then.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ") // whitespace is allowed around the equals sign
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, s = rest[1:], ""
+			} else {
+				value, s = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
@@ -0,0 +1,88 @@
+package shelly
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]string
+	}{
+		{name: "quoted", in: `realm="shellypro3em-abc", nonce="60dc59c6"`, want: map[string]string{"realm": "shellypro3em-abc", "nonce": "60dc59c6"}},
+		{name: "unquoted", in: `qop=auth,algorithm=SHA-256`, want: map[string]string{"qop": "auth", "algorithm": "SHA-256"}},
+		{name: "comma in quotes", in: `qop="auth,auth-int", realm="a, b"`, want: map[string]string{"qop": "auth,auth-int", "realm": "a, b"}},
+		{name: "case and spaces", in: ` Realm = "r" ,  NONCE="n"`, want: map[string]string{"realm": "r", "nonce": "n"}},
+		{name: "unterminated quote", in: `realm="r, nonce=n`, want: map[string]string{"realm": "r, nonce=n"}},
+		{name: "empty", in: "", want: map[string]string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseAuthParams(tc.in); !maps.Equal(got, tc.want) {
+				t.Errorf("parseAuthParams(%q) = %v, want %v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func sha256Hex(parts ...string) string {
+	d := sha256.Sum256([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(d[:])
+}
+
+func md5Hex(parts ...string) string {
+	d := md5.Sum([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(d[:])
+}
+
+func TestDigestAuthorizationSHA256(t *testing.T) {
+	challenge := `Digest qop="auth", realm="shellypro3em-abc", nonce="60dc59c6", algorithm=SHA-256, opaque="xyz"`
+	auth, err := digestAuthorization(challenge, "POST", "/rpc", "admin", "secret")
+	if err != nil {
+		t.Fatalf("digestAuthorization() failed: %s", err)
+	}
+	scheme, params, _ := strings.Cut(auth, " ")
+	if scheme != "Digest" {
+		t.Fatalf("digestAuthorization() returned scheme %q, want Digest", scheme)
+	}
+	p := parseAuthParams(params)
+	for key, want := range map[string]string{"username": "admin", "realm": "shellypro3em-abc", "nonce": "60dc59c6", "uri": "/rpc", "algorithm": "SHA-256", "qop": "auth", "nc": "00000001", "opaque": "xyz"} {
+		if p[key] != want {
+			t.Errorf("digestAuthorization() returned %s %q, want %q", key, p[key], want)
+		}
+	}
+	if p["cnonce"] == "" {
+		t.Fatalf("digestAuthorization() returned no cnonce")
+	}
+	ha1 := sha256Hex("admin", "shellypro3em-abc", "secret")
+	ha2 := sha256Hex("POST", "/rpc")
+	if want := sha256Hex(ha1, "60dc59c6", "00000001", p["cnonce"], "auth", ha2); p["response"] != want {
+		t.Errorf("digestAuthorization() returned response %q, want %q", p["response"], want)
+	}
+}
+
+func TestDigestAuthorizationMD5(t *testing.T) {
+	auth, err := digestAuthorization(`Digest realm="r", nonce="n"`, "GET", "/status", "admin", "secret")
+	if err != nil {
+		t.Fatalf("digestAuthorization() failed: %s", err)
+	}
+	p := parseAuthParams(strings.TrimPrefix(auth, "Digest "))
+	want := md5Hex(md5Hex("admin", "r", "secret"), "n", md5Hex("GET", "/status"))
+	if p["response"] != want || p["qop"] != "" || p["cnonce"] != "" {
+		t.Errorf("digestAuthorization() = %q, want response %q without qop", auth, want)
+	}
+}
+
+func TestDigestAuthorizationUnsupported(t *testing.T) {
+	for _, challenge := range []string{`Basic realm="r"`, `Digest realm="r", nonce="n", algorithm=SHA-512-256`} {
+		if _, err := digestAuthorization(challenge, "GET", "/", "admin", "secret"); err == nil {
+			t.Errorf("digestAuthorization(%q) succeeded, want error", challenge)
+		}
+	}
+}
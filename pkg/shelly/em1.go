@@ -0,0 +1,43 @@
+package shelly
+
+import (
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+func init() {
+	RegisterDeviceType(&em1{})
+}
+
+// em1 are single phase energy meters, e.g. the Shelly EM.
+type em1 struct{}
+
+func (em1) Name() string {
+	return "em-1"
+}
+
+func (em1) Models() []string {
+	return []string{
+		"SHEM",         // Shelly EM
+		"S3EM-002CXEU", // Shelly EM Gen3
+	}
+}
+
+func (em1) CloudPath() string {
+	return powerConsumptionPath
+}
+
+func (em1) Phases() int {
+	return 1
+}
+
+func (em1) Channels() []*config.Channel {
+	return []*config.Channel{{Index: 0}}
+}
+
+func (em1) LocalHistory() bool {
+	return true
+}
+
+func (t em1) Decode(body []byte) (*Series, error) {
+	return decodeSeries(body, t.Phases())
+}
@@ -0,0 +1,46 @@
+package shelly
+
+import (
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+func init() {
+	RegisterDeviceType(&em3p{})
+}
+
+// em3p are three phase energy meters, e.g. the Shelly 3EM or Pro 3EM.
+type em3p struct{}
+
+func (em3p) Name() string {
+	return "em-3p"
+}
+
+func (em3p) Models() []string {
+	return []string{
+		"SHEM-3",           // Shelly 3EM
+		"SPEM-003CEBEU",    // Shelly Pro 3EM
+		"SPEM-003CEBEU120", // Shelly Pro 3EM-120
+		"SPEM-003CEBEU400", // Shelly Pro 3EM-400
+		"S3EM-003CXCEU63",  // Shelly 3EM-63 Gen3
+	}
+}
+
+func (em3p) CloudPath() string {
+	return powerConsumptionPath + "/em-3p"
+}
+
+func (em3p) Phases() int {
+	return 3
+}
+
+func (em3p) Channels() []*config.Channel {
+	return []*config.Channel{{Index: 0}}
+}
+
+func (em3p) LocalHistory() bool {
+	return true
+}
+
+func (t em3p) Decode(body []byte) (*Series, error) {
+	return decodeSeries(body, t.Phases())
+}
@@ -0,0 +1,240 @@
+package shelly
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gen1SettingsPath = "/settings"
+	gen1EMDataPath   = "/emeter/%d/em_data.csv"
+	gen1TimeFmt      = "2006-01-02 15:04"
+)
+
+// Gen1Client reads the per-minute energy history stored on Gen1 energy meters (Shelly EM and
+// Shelly 3EM) through the em_data.csv files of their local HTTP API.
+type Gen1Client struct {
+	httpClient *http.Client
+	timeout    time.Duration
+	logger     *log.Logger
+}
+
+// NewGen1Client returns a client for the local HTTP API of Gen1 devices. If httpClient is nil,
+// http.DefaultClient is used. A timeout of 0 disables the per request timeout. Requests are only
+// logged if logger is set.
+func NewGen1Client(httpClient *http.Client, timeout time.Duration, logger *log.Logger) *Gen1Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Gen1Client{
+		httpClient: httpClient,
+		timeout:    timeout,
+		logger:     logger,
+	}
+}
+
+// PowerConsumption returns the power consumption statistics of the device in the given timeframe
+// aggregated per interval. For three phase devices (Shelly 3EM) the emeters 0 to 2 are read as
+// phases A to C and the channel is ignored. For single phase devices (Shelly EM) the channel
+// selects the emeter.
+func (c *Gen1Client) PowerConsumption(ctx context.Context, dev *Device, channel int, interval string, from, to time.Time) (*PowerConsumptionStatistics, error) {
+	devType, ok := LookupDeviceType(dev.Type)
+	if !ok {
+		return nil, fmt.Errorf("device type %q is not supported", dev.Type)
+	}
+	if !devType.LocalHistory() {
+		return nil, fmt.Errorf("device type %q does not store an energy history locally", dev.Type)
+	}
+
+	// Each phase of a multi phase device is a separate emeter.
+	emeters := []int{channel}
+	if devType.Phases() > 1 {
+		emeters = []int{}
+		for i := 0; i < devType.Phases(); i++ {
+			emeters = append(emeters, i)
+		}
+	}
+
+	logf(c.logger, "requesting stats from local device %q (%s, channel %d) from %q to %q\n", dev.Name, dev.Host, channel, from.Format(DateTimeFmt), to.Format(DateTimeFmt))
+
+	// The records are stored in UTC, so the timeframe needs to be converted from the wall clock
+	// time of the device.
+	var reported string
+	if dev.Timezone == "" {
+		settings, err := c.settings(ctx, dev)
+		if err != nil {
+			return nil, err
+		}
+		reported = settings.Timezone
+	}
+	loc, err := resolveLocation(dev.Timezone, reported)
+	if err != nil {
+		return nil, err
+	}
+	from = inLocation(from, loc)
+	to = inLocation(to, loc)
+
+	records := [][]*record{}
+	for _, emeter := range emeters {
+		r, err := c.emData(ctx, dev, emeter, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read data of emeter %d: %s", emeter, err)
+		}
+		records = append(records, r)
+	}
+
+	return newLocalStatistics(devType, channel, interval, loc, records)
+}
+
+type gen1Settings struct {
+	Timezone string `json:"timezone"`
+}
+
+// settings returns the settings of the device.
+func (c *Gen1Client) settings(ctx context.Context, dev *Device) (*gen1Settings, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	resp, err := c.get(ctx, dev, gen1SettingsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	settings := &gen1Settings{}
+	if err := json.NewDecoder(resp.Body).Decode(settings); err != nil {
+		return nil, fmt.Errorf("unable to parse settings as JSON: %s", err)
+	}
+	return settings, nil
+}
+
+// emData downloads and parses the em_data.csv of the emeter and returns the records in the
+// given timeframe in its location.
+func (c *Gen1Client) emData(ctx context.Context, dev *Device, emeter int, from, to time.Time) ([]*record, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	resp, err := c.get(ctx, dev, fmt.Sprintf(gen1EMDataPath, emeter))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseEMData(resp.Body, from, to)
+}
+
+// get sends a GET request for the path to the device and returns the response if successful.
+func (c *Gen1Client) get(ctx context.Context, dev *Device, path string) (*http.Response, error) {
+	u := &url.URL{Scheme: "http", Host: dev.Host, Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+	if dev.Password != "" {
+		req.SetBasicAuth(dev.Username, dev.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch data: %s", err)
+	}
+	if http.StatusOK != resp.StatusCode {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to fetch data (response code %d): %s", resp.StatusCode, b)
+	}
+	return resp, nil
+}
+
+// parseEMData parses an em_data.csv as provided by Gen1 devices and returns the records in the
+// given timeframe in the location of from. The file starts with a header like
+//
+//	Date/time UTC,Active energy Wh,Returned energy Wh,Min V,Max V
+//
+// followed by one row per minute.
+func parseEMData(r io.Reader, from, to time.Time) ([]*record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %s", err)
+	}
+	cols := map[string]int{}
+	for i, col := range header {
+		cols[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	idx := func(name string) (int, error) {
+		i, ok := cols[name]
+		if !ok {
+			return 0, fmt.Errorf("column %q is missing", name)
+		}
+		return i, nil
+	}
+	dateIdx, err := idx("date/time utc")
+	if err != nil {
+		return nil, err
+	}
+	consumptionIdx, err := idx("active energy wh")
+	if err != nil {
+		return nil, err
+	}
+	reversedIdx, err := idx("returned energy wh")
+	if err != nil {
+		return nil, err
+	}
+	minVoltageIdx, minErr := idx("min v")
+	maxVoltageIdx, maxErr := idx("max v")
+
+	records := []*record{}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read row: %s", err)
+		}
+
+		ts, err := time.Parse(gen1TimeFmt, row[dateIdx])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse date/time %q: %s", row[dateIdx], err)
+		}
+		ts = ts.In(from.Location())
+		if ts.Before(from) || !ts.Before(to) {
+			continue
+		}
+
+		rec := &record{time: ts}
+		if rec.consumption, err = strconv.ParseFloat(row[consumptionIdx], 64); err != nil {
+			return nil, fmt.Errorf("unable to parse active energy %q: %s", row[consumptionIdx], err)
+		}
+		if rec.reversed, err = strconv.ParseFloat(row[reversedIdx], 64); err != nil {
+			return nil, fmt.Errorf("unable to parse returned energy %q: %s", row[reversedIdx], err)
+		}
+		if minErr == nil {
+			rec.minVoltage, _ = strconv.ParseFloat(row[minVoltageIdx], 64)
+		}
+		if maxErr == nil {
+			rec.maxVoltage, _ = strconv.ParseFloat(row[maxVoltageIdx], 64)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
@@ -0,0 +1,68 @@
+package shelly
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEMData(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Minute)
+	rows := "2024-02-29 23:59,9,9,230,231\n2024-03-01 00:00,1.5,0,229.5,231\n2024-03-01 00:01,2,0.5,228,232\n2024-03-01 00:02,9,9,230,231\n"
+
+	tests := []struct {
+		name        string
+		data        string
+		wantVoltage bool
+		wantErr     bool
+	}{
+		{name: "default header", data: "Date/time UTC,Active energy Wh,Returned energy Wh,Min V,Max V\n" + rows, wantVoltage: true},
+		{name: "case and spaces", data: "date/time utc, ACTIVE ENERGY WH , Returned Energy Wh,min v,MAX V\n" + rows, wantVoltage: true},
+		{name: "without voltage", data: "Date/time UTC,Active energy Wh,Returned energy Wh\n2024-03-01 00:00,1.5,0\n2024-03-01 00:01,2,0.5\n2024-03-01 00:02,9,9\n"},
+		{name: "missing column", data: "Date/time UTC,Active energy Wh\n2024-03-01 00:00,1.5\n", wantErr: true},
+		{name: "invalid time", data: "Date/time UTC,Active energy Wh,Returned energy Wh\n2024-03-01T00:00,1.5,0\n", wantErr: true},
+		{name: "invalid energy", data: "Date/time UTC,Active energy Wh,Returned energy Wh\n2024-03-01 00:00,n/a,0\n", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := parseEMData(strings.NewReader(tc.data), from, to)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseEMData() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEMData() failed: %s", err)
+			}
+			// The rows before from and at to are outside of the timeframe.
+			if len(records) != 2 {
+				t.Fatalf("parseEMData() returned %d records, want 2", len(records))
+			}
+			if got := records[1]; !got.time.Equal(from.Add(time.Minute)) || got.consumption != 2 || got.reversed != 0.5 {
+				t.Errorf("parseEMData() returned record (%s, %v Wh, %v Wh), want (%s, 2 Wh, 0.5 Wh)", got.time, got.consumption, got.reversed, from.Add(time.Minute))
+			}
+			if got := records[0]; tc.wantVoltage && (got.minVoltage != 229.5 || got.maxVoltage != 231) {
+				t.Errorf("parseEMData() returned voltage %v to %v, want 229.5 to 231", got.minVoltage, got.maxVoltage)
+			}
+		})
+	}
+}
+
+func TestParseEMDataLocation(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("timezone database not available: %s", err)
+	}
+	// Rows are in UTC, the timeframe is local midnight to midnight.
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, zurich)
+	data := "Date/time UTC,Active energy Wh,Returned energy Wh\n2024-02-29 22:59,1,0\n2024-02-29 23:00,2,0\n"
+	records, err := parseEMData(strings.NewReader(data), from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("parseEMData() failed: %s", err)
+	}
+	if len(records) != 1 || !records[0].time.Equal(from) || records[0].time.Location() != zurich {
+		t.Errorf("parseEMData() returned %d records, want the one at %s", len(records), from)
+	}
+}
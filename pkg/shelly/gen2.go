@@ -0,0 +1,269 @@
+package shelly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	gen2RPCPath = "/rpc"
+)
+
+// Gen2Client reads the energy history stored on Gen2+ energy meters through their local RPC API
+// (EMData for three phase and EM1Data for single phase meters).
+type Gen2Client struct {
+	httpClient *http.Client
+	timeout    time.Duration
+	logger     *log.Logger
+}
+
+// NewGen2Client returns a client for the local RPC API of Gen2+ devices. If httpClient is nil,
+// http.DefaultClient is used. A timeout of 0 disables the per request timeout. Requests are only
+// logged if logger is set.
+func NewGen2Client(httpClient *http.Client, timeout time.Duration, logger *log.Logger) *Gen2Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Gen2Client{
+		httpClient: httpClient,
+		timeout:    timeout,
+		logger:     logger,
+	}
+}
+
+type rpcRequest struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type gen2SysConfig struct {
+	Location struct {
+		TZ string `json:"tz"`
+	} `json:"location"`
+}
+
+type emDataRecords struct {
+	DataBlocks []struct {
+		TS      int64 `json:"ts"`
+		Period  int64 `json:"period"`
+		Records int64 `json:"records"`
+	} `json:"data_blocks"`
+}
+
+type emData struct {
+	Keys []string `json:"keys"`
+	Data []struct {
+		TS     int64       `json:"ts"`
+		Period int64       `json:"period"`
+		Values [][]float64 `json:"values"`
+	} `json:"data"`
+	NextRecordTS int64 `json:"next_record_ts"`
+}
+
+// PowerConsumption returns the power consumption statistics of the device channel (i.e. the ID of
+// the EM or EM1 component) in the given timeframe aggregated per interval.
+func (c *Gen2Client) PowerConsumption(ctx context.Context, dev *Device, channel int, interval string, from, to time.Time) (*PowerConsumptionStatistics, error) {
+	devType, ok := LookupDeviceType(dev.Type)
+	if !ok {
+		return nil, fmt.Errorf("device type %q is not supported", dev.Type)
+	}
+	if !devType.LocalHistory() {
+		return nil, fmt.Errorf("device type %q does not store an energy history locally", dev.Type)
+	}
+
+	// Single phase meters store their data in EM1Data, multi phase meters in EMData with the
+	// keys of each phase prefixed by its letter.
+	component := "EM1Data"
+	phases := []string{""}
+	if devType.Phases() > 1 {
+		component = "EMData"
+		phases = []string{}
+		for i := 0; i < devType.Phases(); i++ {
+			phases = append(phases, fmt.Sprintf("%c_", 'a'+i))
+		}
+	}
+
+	logf(c.logger, "requesting stats from local device %q (%s, channel %d) from %q to %q\n", dev.Name, dev.Host, channel, from.Format(DateTimeFmt), to.Format(DateTimeFmt))
+
+	// The records are stored with UNIX timestamps, so the timeframe needs to be converted from
+	// the wall clock time of the device.
+	var reported string
+	if dev.Timezone == "" {
+		var sysConfig gen2SysConfig
+		if err := c.call(ctx, dev, "Sys.GetConfig", nil, &sysConfig); err != nil {
+			return nil, err
+		}
+		reported = sysConfig.Location.TZ
+	}
+	loc, err := resolveLocation(dev.Timezone, reported)
+	if err != nil {
+		return nil, err
+	}
+	from = inLocation(from, loc)
+	to = inLocation(to, loc)
+
+	// Only ask for the records the device actually has.
+	var available emDataRecords
+	if err := c.call(ctx, dev, component+".GetRecords", map[string]interface{}{"id": channel, "ts": from.Unix()}, &available); err != nil {
+		return nil, err
+	}
+	start := from.Unix()
+	if len(available.DataBlocks) > 0 && available.DataBlocks[0].TS > start {
+		logf(c.logger, "local device %q (%s) only has records since %s\n", dev.Name, dev.Host, time.Unix(available.DataBlocks[0].TS, 0).In(loc).Format(time.RFC3339))
+		start = available.DataBlocks[0].TS
+	}
+
+	records := make([][]*record, len(phases))
+	for ts := start; ts < to.Unix(); {
+		var data emData
+		if err := c.call(ctx, dev, component+".GetData", map[string]interface{}{"id": channel, "ts": ts, "end_ts": to.Unix() - 1}, &data); err != nil {
+			return nil, err
+		}
+		for i, pfx := range phases {
+			r, err := emDataRecordsFor(&data, pfx, to)
+			if err != nil {
+				return nil, err
+			}
+			records[i] = append(records[i], r...)
+		}
+		if data.NextRecordTS <= ts {
+			break
+		}
+		ts = data.NextRecordTS
+	}
+
+	return newLocalStatistics(devType, channel, interval, loc, records)
+}
+
+// emDataRecordsFor returns the records of the phase with the given key prefix ("" for EM1Data)
+// from the data returned by GetData which lie before to. The records are in the location of to.
+func emDataRecordsFor(data *emData, pfx string, to time.Time) ([]*record, error) {
+	idx := map[string]int{}
+	for i, key := range data.Keys {
+		idx[key] = i
+	}
+	consumption, ok := idx[pfx+"total_act_energy"]
+	if !ok {
+		return nil, fmt.Errorf("key %q is missing in data", pfx+"total_act_energy")
+	}
+	reversed, ok := idx[pfx+"total_act_ret_energy"]
+	if !ok {
+		return nil, fmt.Errorf("key %q is missing in data", pfx+"total_act_ret_energy")
+	}
+	minVoltage, hasMinVoltage := idx[pfx+"min_voltage"]
+	maxVoltage, hasMaxVoltage := idx[pfx+"max_voltage"]
+
+	records := []*record{}
+	for _, block := range data.Data {
+		for i, values := range block.Values {
+			ts := time.Unix(block.TS+int64(i)*block.Period, 0).In(to.Location())
+			if !ts.Before(to) {
+				break
+			}
+			if len(values) != len(data.Keys) {
+				return nil, fmt.Errorf("record at %s has %d values, expected %d", ts.Format(time.RFC3339), len(values), len(data.Keys))
+			}
+			r := &record{
+				time:        ts,
+				consumption: values[consumption],
+				reversed:    values[reversed],
+			}
+			if hasMinVoltage {
+				r.minVoltage = values[minVoltage]
+			}
+			if hasMaxVoltage {
+				r.maxVoltage = values[maxVoltage]
+			}
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// call invokes the RPC method on the device and decodes its result into result. If the device
+// asks for authentication, the request is repeated with digest authentication.
+func (c *Gen2Client) call(ctx context.Context, dev *Device, method string, params, result interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(&rpcRequest{ID: 1, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("unable to encode request: %s", err)
+	}
+	u := &url.URL{Scheme: "http", Host: dev.Host, Path: gen2RPCPath}
+
+	resp, err := c.post(ctx, u, body, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		if dev.Password == "" {
+			return fmt.Errorf("device %s requires a password", dev.Host)
+		}
+		auth, err := digestAuthorization(resp.Header.Get("WWW-Authenticate"), http.MethodPost, u.RequestURI(), dev.Username, dev.Password)
+		if err != nil {
+			return fmt.Errorf("unable to authenticate: %s", err)
+		}
+		if resp, err = c.post(ctx, u, body, auth); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response body: %s", err)
+	}
+	if http.StatusOK != resp.StatusCode {
+		return fmt.Errorf("unable to call %s (response code %d): %s", method, resp.StatusCode, b)
+	}
+
+	rpcResp := &rpcResponse{}
+	if err := json.Unmarshal(b, rpcResp); err != nil {
+		return fmt.Errorf("unable to parse body as JSON: %s", err)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("unable to call %s (error code %d): %s", method, rpcResp.Error.Code, rpcResp.Error.Message)
+	}
+	if err := json.Unmarshal(rpcResp.Result, result); err != nil {
+		return fmt.Errorf("unable to parse result of %s: %s", method, err)
+	}
+	return nil
+}
+
+func (c *Gen2Client) post(ctx context.Context, u *url.URL, body []byte, auth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch data: %s", err)
+	}
+	return resp, nil
+}
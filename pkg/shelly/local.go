@@ -0,0 +1,85 @@
+package shelly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+// record is a single energy record of one phase as stored on a device.
+type record struct {
+	time        time.Time
+	consumption float64 // Wh
+	reversed    float64 // Wh
+	minVoltage  float64
+	maxVoltage  float64
+}
+
+// aggregate sums up the records of each phase into buckets of the given interval. All phases
+// end up with entries for the same buckets; buckets without records for a phase are marked as
+// missing.
+func aggregate(interval string, phases [][]*record) [][]*Entry {
+	buckets := map[time.Time]bool{}
+	sums := make([]map[time.Time]*Entry, len(phases))
+	for i, records := range phases {
+		sums[i] = map[time.Time]*Entry{}
+		for _, r := range records {
+			start := bucketStart(interval, r.time)
+			buckets[start] = true
+			e, ok := sums[i][start]
+			if !ok {
+				e = &Entry{
+					DateTime:   ShellyTime(start),
+					MinVoltage: math.Inf(1),
+					MaxVoltage: math.Inf(-1),
+				}
+				sums[i][start] = e
+			}
+			e.Consumption += r.consumption
+			e.Reversed += r.reversed
+			e.MinVoltage = math.Min(e.MinVoltage, r.minVoltage)
+			e.MaxVoltage = math.Max(e.MaxVoltage, r.maxVoltage)
+		}
+	}
+
+	times := []time.Time{}
+	for t := range buckets {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	entries := make([][]*Entry, len(phases))
+	for i := range phases {
+		for _, t := range times {
+			e, ok := sums[i][t]
+			if !ok {
+				e = &Entry{DateTime: ShellyTime(t), IsMissing: true, IsAbsent: true}
+			}
+			entries[i] = append(entries[i], e)
+		}
+	}
+	return entries
+}
+
+// newLocalStatistics converts the records read from a device into statistics aggregated per
+// interval. There needs to be one list of records per phase of the device type. The records need
+// to be in the location of the device already.
+func newLocalStatistics(devType DeviceType, channel int, interval string, loc *time.Location, phases [][]*record) (*PowerConsumptionStatistics, error) {
+	if len(phases) != devType.Phases() {
+		return nil, fmt.Errorf("got records for %d phases, expected %d", len(phases), devType.Phases())
+	}
+	stats, err := NewSeries(interval, loc, aggregate(interval, phases))
+	if err != nil {
+		return nil, err
+	}
+	return &PowerConsumptionStatistics{
+		DeviceType: devType,
+		Channel:    &config.Channel{Index: channel},
+		Stats:      stats,
+	}, nil
+}
@@ -0,0 +1,30 @@
+package shelly
+
+import (
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+func init() {
+	RegisterDeviceType(&proEM{})
+}
+
+// proEM are energy meters measuring two independent single phase circuits, e.g. the Shelly Pro
+// EM-50. The statistics of each circuit are those of a single phase meter and both circuits are
+// exported side by side unless only one is configured.
+type proEM struct {
+	em1
+}
+
+func (proEM) Name() string {
+	return "pro-em"
+}
+
+func (proEM) Models() []string {
+	return []string{
+		"SPEM-002CEBEU50", // Shelly Pro EM-50
+	}
+}
+
+func (proEM) Channels() []*config.Channel {
+	return []*config.Channel{{Index: 0}, {Index: 1}}
+}
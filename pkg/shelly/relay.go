@@ -0,0 +1,91 @@
+package shelly
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+const (
+	relayConsumptionPath = "/statistics/relay/consumption"
+)
+
+func init() {
+	RegisterDeviceType(&relay{
+		name: "switch",
+		models: []string{
+			"SHSW-PM",        // Shelly 1PM
+			"SHSW-25",        // Shelly 2.5
+			"SNSW-001P16EU",  // Shelly Plus 1PM
+			"SNSW-102P16EU",  // Shelly Plus 2PM
+			"S3SW-001P16EU",  // Shelly 1PM Gen3
+			"SPSW-201PE16EU", // Shelly Pro 1PM
+			"SPSW-202PE16EU", // Shelly Pro 2PM
+			"SPSW-104PE16EU", // Shelly Pro 4PM
+		},
+	})
+	RegisterDeviceType(&relay{
+		name: "plug",
+		models: []string{
+			"SHPLG-S",      // Shelly Plug S
+			"SHPLG2-1",     // Shelly Plug
+			"SNPL-00112EU", // Shelly Plus Plug S
+			"SNPL-00110IT", // Shelly Plus Plug IT
+			"SNPL-00116US", // Shelly Plus Plug US
+			"S3PL-00112EU", // Shelly Plug S Gen3
+		},
+	})
+}
+
+// relay are switches and plugs which measure the consumption of their outputs. Each output is a
+// channel. Their statistics are provided by a different cloud endpoint than the ones of energy
+// meters which wraps the statistics in a data object.
+type relay struct {
+	name   string
+	models []string
+}
+
+type relayConsumption struct {
+	IsOK   bool            `json:"isok"`
+	Errors json.RawMessage `json:"errors"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func (r *relay) Name() string {
+	return r.name
+}
+
+func (r *relay) Models() []string {
+	return r.models
+}
+
+func (r *relay) CloudPath() string {
+	return relayConsumptionPath
+}
+
+func (r *relay) Phases() int {
+	return 1
+}
+
+func (r *relay) Channels() []*config.Channel {
+	return []*config.Channel{{Index: 0}}
+}
+
+func (r *relay) LocalHistory() bool {
+	return false
+}
+
+func (r *relay) Decode(body []byte) (*Series, error) {
+	resp := &relayConsumption{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("unable to parse body as JSON: %s", err)
+	}
+	if !resp.IsOK {
+		return nil, fmt.Errorf("unable to get consumption: %s", resp.Errors)
+	}
+	if len(resp.Data) == 0 || string(resp.Data) == "null" {
+		return nil, fmt.Errorf("consumption is missing in response")
+	}
+	return decodeSeries(resp.Data, r.Phases())
+}
@@ -0,0 +1,422 @@
+package shelly
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+// TotalSeries is the name of the total of all phases.
+const TotalSeries = "total"
+
+// Series holds the statistics of one channel of a device as buckets of the interval. Each bucket
+// holds the entry of every phase of the device along with their total. Single phase devices have
+// no named phases, their buckets only hold the total.
+type Series struct {
+	Timezone string
+	Interval string
+	Phases   []string  // names of the phases, e.g. "phase_a"
+	Buckets  []*Bucket // sorted by time
+
+	location *time.Location
+}
+
+// Bucket holds the entries of all phases of a series starting at the same time.
+type Bucket struct {
+	Time   time.Time
+	Phases []*Entry // one per phase of the series
+	Total  *Entry
+}
+
+// setTime moves the bucket and all its entries to the given start.
+func (b *Bucket) setTime(t time.Time) {
+	b.Time = t
+	for _, e := range b.Phases {
+		e.DateTime = ShellyTime(t)
+	}
+	b.Total.DateTime = ShellyTime(t)
+}
+
+// combineBuckets combines the entries of two buckets of the same series phase by phase.
+func combineBuckets(a, b *Bucket) *Bucket {
+	combined := &Bucket{Time: a.Time, Total: combineEntries(a.Total, b.Total)}
+	for i := range a.Phases {
+		combined.Phases = append(combined.Phases, combineEntries(a.Phases[i], b.Phases[i]))
+	}
+	return combined
+}
+
+// sumPhases returns the total of the entries of all phases.
+func sumPhases(phases []*Entry) *Entry {
+	total := phases[0]
+	for _, e := range phases[1:] {
+		total = combineEntries(total, e)
+	}
+	return total
+}
+
+// phaseNames returns the names of the phases of a device measuring the given number of phases.
+// Single phase devices have no named phases.
+func phaseNames(phases int) []string {
+	if phases < 2 {
+		return nil
+	}
+	names := []string{}
+	for i := 0; i < phases; i++ {
+		names = append(names, fmt.Sprintf("phase_%c", 'a'+i))
+	}
+	return names
+}
+
+// newSeries returns a series of the entries of each phase. All phases need to have entries for
+// the same buckets in the same order. The totals are computed from the phases unless
+// they are given (e.g. as reported by the Shelly cloud).
+func newSeries(interval string, phases [][]*Entry, totals []*Entry) (*Series, error) {
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("got entries for no phase")
+	}
+	s := &Series{
+		Interval: interval,
+		Phases:   phaseNames(len(phases)),
+	}
+	if len(phases) == 1 {
+		for _, e := range phases[0] {
+			s.Buckets = append(s.Buckets, &Bucket{Time: time.Time(e.DateTime), Total: e})
+		}
+		return s, nil
+	}
+
+	for i, entries := range phases {
+		if len(entries) != len(phases[0]) {
+			return nil, fmt.Errorf("got %d entries for %s, expected %d", len(entries), s.Phases[i], len(phases[0]))
+		}
+	}
+	if totals != nil && len(totals) != len(phases[0]) {
+		return nil, fmt.Errorf("got %d totals, expected %d", len(totals), len(phases[0]))
+	}
+	for i := range phases[0] {
+		b := &Bucket{}
+		for _, entries := range phases {
+			b.Phases = append(b.Phases, entries[i])
+		}
+		if totals != nil {
+			b.Total = totals[i]
+		} else {
+			b.Total = sumPhases(b.Phases)
+		}
+		b.Time = time.Time(b.Total.DateTime)
+		s.Buckets = append(s.Buckets, b)
+	}
+	s.Sort()
+	return s, nil
+}
+
+// NewSeries returns a series of the given interval and location holding the entries of each phase,
+// e.g. as aggregated from the records of a device. All phases need to have entries for the same
+// buckets in the same order. The totals are computed from the phases.
+func NewSeries(interval string, loc *time.Location, phases [][]*Entry) (*Series, error) {
+	s, err := newSeries(interval, phases, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.Timezone = loc.String()
+	s.location = loc
+	return s, nil
+}
+
+// cloudSeries is the shape of the statistics returned by the Shelly cloud. Single phase devices
+// report a list of entries as their history, three phase devices a list per phase along with the
+// sum of all phases.
+type cloudSeries struct {
+	Timezone string          `json:"timezone"`
+	Interval string          `json:"interval"`
+	History  json.RawMessage `json:"history"`
+	Sum      []*Entry        `json:"sum"`
+}
+
+// decodeSeries decodes the statistics returned by the Shelly cloud for a device measuring the
+// given number of phases.
+func decodeSeries(body []byte, phases int) (*Series, error) {
+	cs := &cloudSeries{}
+	if err := json.Unmarshal(body, cs); err != nil {
+		return nil, fmt.Errorf("unable to parse body as JSON: %s", err)
+	}
+
+	history := [][]*Entry{}
+	if phases == 1 {
+		entries := []*Entry{}
+		if len(cs.History) > 0 {
+			if err := json.Unmarshal(cs.History, &entries); err != nil {
+				return nil, fmt.Errorf("unable to parse history as JSON: %s", err)
+			}
+		}
+		history = append(history, entries)
+	} else {
+		if len(cs.History) > 0 {
+			if err := json.Unmarshal(cs.History, &history); err != nil {
+				return nil, fmt.Errorf("unable to parse history as JSON: %s", err)
+			}
+		}
+		if len(history) != phases {
+			return nil, fmt.Errorf("got history for %d phases, expected %d", len(history), phases)
+		}
+	}
+
+	s, err := newSeries(cs.Interval, history, cs.Sum)
+	if err != nil {
+		return nil, err
+	}
+	s.Timezone = cs.Timezone
+	return s, nil
+}
+
+// Location returns the location the buckets are in (UTC unless localized).
+func (s *Series) Location() *time.Location {
+	if s.location == nil {
+		return time.UTC
+	}
+	return s.location
+}
+
+// Localize interprets the wall clock times of all buckets in the given location.
+func (s *Series) Localize(loc *time.Location) {
+	for _, b := range s.Buckets {
+		b.setTime(inLocation(b.Time, loc))
+	}
+	s.Timezone = loc.String()
+	s.location = loc
+}
+
+func (s *Series) Sort() {
+	sort.Slice(s.Buckets, func(i, j int) bool {
+		return s.Buckets[i].Time.Before(s.Buckets[j].Time)
+	})
+}
+
+// PhaseEntries returns the entries of the i-th phase.
+func (s *Series) PhaseEntries(i int) []*Entry {
+	entries := []*Entry{}
+	for _, b := range s.Buckets {
+		entries = append(entries, b.Phases[i])
+	}
+	return entries
+}
+
+// Totals returns the entries summed up over all phases.
+func (s *Series) Totals() []*Entry {
+	entries := []*Entry{}
+	for _, b := range s.Buckets {
+		entries = append(entries, b.Total)
+	}
+	return entries
+}
+
+// Entries returns the entries of each phase followed by the totals.
+func (s *Series) Entries() [][]*Entry {
+	entries := [][]*Entry{}
+	for i := range s.Phases {
+		entries = append(entries, s.PhaseEntries(i))
+	}
+	return append(entries, s.Totals())
+}
+
+// MergeReport summarizes how the buckets present in both series were merged by Add.
+type MergeReport struct {
+	Overlapping int // buckets present in both series
+	Conflicting int // overlapping buckets with different values
+	Replaced    int // overlapping buckets taken from the added series
+}
+
+// Add merges the (normalized) series of the following timeframe of the same device bucket by
+// bucket. Buckets present in both series (e.g. the boundary of two chunks) are resolved according
+// to the policy (see config.MergeNewer and the like), where the added series counts as the newer
+// one so that the result only depends on the order the series are added in.
+func (s *Series) Add(other *Series, policy string) (*MergeReport, error) {
+	if s.Timezone != other.Timezone {
+		return nil, fmt.Errorf("timezone of this stats (%q) is different from the one to be added (%q)", s.Timezone, other.Timezone)
+	}
+	if s.Interval != other.Interval {
+		return nil, fmt.Errorf("interval of this stats (%q) is different from the one to be added (%q)", s.Interval, other.Interval)
+	}
+	if len(s.Phases) != len(other.Phases) {
+		return nil, fmt.Errorf("phases of this stats (%d) are different from the ones to be added (%d)", len(s.Phases), len(other.Phases))
+	}
+
+	report := &MergeReport{}
+	index := map[int64]int{}
+	for i, b := range s.Buckets {
+		index[b.Time.Unix()] = i
+	}
+	for _, b := range other.Buckets {
+		i, ok := index[b.Time.Unix()]
+		if !ok {
+			index[b.Time.Unix()] = len(s.Buckets)
+			s.Buckets = append(s.Buckets, b)
+			continue
+		}
+
+		report.Overlapping++
+		existing := s.Buckets[i]
+		if sameBucket(existing, b) {
+			continue
+		}
+		report.Conflicting++
+		replace := true
+		switch policy {
+		case config.MergeError:
+			return nil, fmt.Errorf("bucket %s differs between fetches", b.Time.Format(time.RFC3339))
+		case config.MergeNonMissing:
+			if existing.Total.IsMissing != b.Total.IsMissing {
+				replace = existing.Total.IsMissing
+			}
+		}
+		if replace {
+			s.Buckets[i] = b
+			report.Replaced++
+		}
+	}
+	s.Sort()
+	return report, nil
+}
+
+// sameBucket returns whether the entries of both buckets have the same energy and are both either
+// missing or not.
+func sameBucket(a, b *Bucket) bool {
+	entries := append(slices.Clone(a.Phases), a.Total)
+	others := append(slices.Clone(b.Phases), b.Total)
+	for i, e := range entries {
+		o := others[i]
+		if e.Consumption != o.Consumption || e.Reversed != o.Reversed || e.IsMissing != o.IsMissing {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize rolls the buckets up into buckets of the given interval in a single pass. Buckets
+// which do not start on a boundary of the interval (e.g. at 00:15 or at midnight UTC instead of
+// local midnight) are assigned to the bucket they fall into in the location of the series.
+func (s *Series) Normalize(interval string) {
+	s.Interval = interval
+	buckets := []*Bucket{}
+	index := map[int64]int{}
+	for _, b := range s.Buckets {
+		start := bucketStart(interval, b.Time)
+		if i, ok := index[start.Unix()]; ok {
+			buckets[i] = combineBuckets(buckets[i], b)
+			continue
+		}
+		b.setTime(start)
+		index[start.Unix()] = len(buckets)
+		buckets = append(buckets, b)
+	}
+	s.Buckets = buckets
+	s.Sort()
+}
+
+// Clip drops the buckets outside of the timeframe and returns them. The bucket from falls into is
+// kept. The wall clock times of from and to are interpreted in the location of the series.
+func (s *Series) Clip(from, to time.Time) []*Bucket {
+	first := bucketStart(s.Interval, inLocation(from, s.Location()))
+	to = inLocation(to, s.Location())
+
+	buckets := []*Bucket{}
+	dropped := []*Bucket{}
+	for _, b := range s.Buckets {
+		if b.Time.Before(first) || !b.Time.Before(to) {
+			dropped = append(dropped, b)
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+	s.Buckets = buckets
+	return dropped
+}
+
+// dropBefore drops the buckets starting before the wall clock time t in the location of the series.
+func (s *Series) dropBefore(t time.Time) {
+	t = inLocation(t, s.Location())
+	buckets := []*Bucket{}
+	for _, b := range s.Buckets {
+		if !b.Time.Before(t) {
+			buckets = append(buckets, b)
+		}
+	}
+	s.Buckets = buckets
+}
+
+// Complete adds a bucket marked as missing and absent for every bucket of the interval within the timeframe
+// which the series has no bucket for and returns the number of added buckets. The wall clock
+// times of from and to are interpreted in the location of the series.
+func (s *Series) Complete(from, to time.Time) int {
+	present := map[string]bool{}
+	for _, b := range s.Buckets {
+		present[b.Time.Format(DateTimeFmt)] = true
+	}
+
+	added := 0
+	to = inLocation(to, s.Location())
+	for date := bucketStart(s.Interval, inLocation(from, s.Location())); date.Before(to); date = nextBucket(s.Interval, date) {
+		// Buckets are identified by their wall clock time as they were localized that way.
+		if present[date.Format(DateTimeFmt)] {
+			continue
+		}
+		present[date.Format(DateTimeFmt)] = true
+		b := &Bucket{Time: date, Total: &Entry{DateTime: ShellyTime(date), IsMissing: true, IsAbsent: true}}
+		for range s.Phases {
+			b.Phases = append(b.Phases, &Entry{DateTime: ShellyTime(date), IsMissing: true, IsAbsent: true})
+		}
+		s.Buckets = append(s.Buckets, b)
+		added++
+	}
+	s.Sort()
+	return added
+}
+
+// SeriesNames returns the names of the phases followed by the total, in the order of Entries.
+func (s *Series) SeriesNames() []string {
+	return append(slices.Clone(s.Phases), TotalSeries)
+}
+
+// Columns returns the columns exported for each bucket given the selected entry fields (see
+// config.SupportedColumns): each field of every phase followed by the total. Whether a bucket is
+// missing or estimated and its anomalies are only exported for the total.
+func (s *Series) Columns(fields []string) []*Column {
+	cols := []*Column{}
+	for _, field := range fields {
+		if !totalOnly(field) {
+			for _, phase := range s.Phases {
+				cols = append(cols, &Column{Name: columnName(phase, field), Field: field})
+			}
+		}
+		cols = append(cols, &Column{Name: columnName(TotalSeries, field), Field: field})
+	}
+	return cols
+}
+
+// Rows returns the values of the exported columns keyed by the start of each bucket.
+func (s *Series) Rows(fields []string) map[time.Time][]interface{} {
+	rows := map[time.Time][]interface{}{}
+	for _, b := range s.Buckets {
+		values := []interface{}{}
+		for _, field := range fields {
+			if !totalOnly(field) {
+				for _, e := range b.Phases {
+					values = append(values, e.Value(field))
+				}
+			}
+			values = append(values, b.Total.Value(field))
+		}
+		rows[b.Time] = values
+	}
+	return rows
+}
+
+// totalOnly returns whether the field is only exported for the total.
+func totalOnly(field string) bool {
+	return field == config.ColumnIsMissing || field == config.ColumnIsEstimated || field == config.ColumnAnomalies
+}
@@ -0,0 +1,166 @@
+package shelly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+// testSeries returns a localized single phase series with an entry per given wall clock time and
+// consumption.
+func testSeries(t *testing.T, interval string, loc *time.Location, entries map[time.Time]float64) *Series {
+	t.Helper()
+	history := []*Entry{}
+	for ts, wh := range entries {
+		history = append(history, &Entry{DateTime: ShellyTime(ts), Consumption: wh})
+	}
+	s, err := newSeries(interval, [][]*Entry{history}, nil)
+	if err != nil {
+		t.Fatalf("newSeries() failed: %s", err)
+	}
+	s.Localize(loc)
+	s.Sort()
+	return s
+}
+
+func day(d int) time.Time {
+	return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAddBoundaryConflict(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantErr     bool
+		wantMissing bool
+		wantWh      float64
+	}{
+		{policy: config.MergeNewer, wantMissing: true, wantWh: 0},
+		{policy: config.MergeNonMissing, wantMissing: false, wantWh: 10},
+		{policy: config.MergeError, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.policy, func(t *testing.T) {
+			// Chunk A covers Jan 1 to 3 and includes Jan 3 (date_to), chunk B starts on Jan 3
+			// which it reports as missing.
+			a := testSeries(t, config.IntervalDay, time.UTC, map[time.Time]float64{day(1): 1, day(2): 2, day(3): 10})
+			b := testSeries(t, config.IntervalDay, time.UTC, map[time.Time]float64{day(3): 0, day(4): 4})
+			b.Buckets[0].Total.IsMissing = true
+
+			report, err := a.Add(b, tc.policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Add() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Add() failed: %s", err)
+			}
+			if report.Overlapping != 1 || report.Conflicting != 1 {
+				t.Errorf("Add() report = %+v, want 1 overlapping and conflicting bucket", report)
+			}
+			if len(a.Buckets) != 4 {
+				t.Fatalf("Add() resulted in %d buckets, want 4", len(a.Buckets))
+			}
+			got := a.Buckets[2].Total
+			if got.IsMissing != tc.wantMissing || got.Consumption != tc.wantWh {
+				t.Errorf("Add() kept bucket (missing %t, %v Wh), want (missing %t, %v Wh)", got.IsMissing, got.Consumption, tc.wantMissing, tc.wantWh)
+			}
+		})
+	}
+}
+
+func TestAddSameBucket(t *testing.T) {
+	a := testSeries(t, config.IntervalDay, time.UTC, map[time.Time]float64{day(1): 1, day(2): 2})
+	b := testSeries(t, config.IntervalDay, time.UTC, map[time.Time]float64{day(2): 2, day(3): 3})
+	report, err := a.Add(b, config.MergeError)
+	if err != nil {
+		t.Fatalf("Add() failed: %s", err)
+	}
+	if report.Overlapping != 1 || report.Conflicting != 0 || report.Replaced != 0 {
+		t.Errorf("Add() report = %+v, want 1 overlapping bucket without conflict", report)
+	}
+}
+
+func TestNormalizeOffBoundary(t *testing.T) {
+	entries := map[time.Time]float64{}
+	for h := 0; h < 24; h++ {
+		entries[day(1).Add(time.Duration(h)*time.Hour+15*time.Minute)] = 1
+	}
+	entries[day(2).Add(15*time.Minute)] = 5
+	s := testSeries(t, config.IntervalHour, time.UTC, entries)
+
+	s.Normalize(config.IntervalDay)
+	if len(s.Buckets) != 2 {
+		t.Fatalf("Normalize() resulted in %d buckets, want 2", len(s.Buckets))
+	}
+	for i, want := range []struct {
+		time time.Time
+		wh   float64
+	}{{day(1), 24}, {day(2), 5}} {
+		got := s.Buckets[i]
+		if !got.Time.Equal(want.time) || got.Total.Consumption != want.wh {
+			t.Errorf("Normalize() bucket %d = (%s, %v Wh), want (%s, %v Wh)", i, got.Time, got.Total.Consumption, want.time, want.wh)
+		}
+	}
+}
+
+func TestNormalizeDST(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("timezone database not available: %s", err)
+	}
+	// The clocks are moved from 02:00 to 03:00 on March 30, 2025, so the day has 23 hours.
+	entries := map[time.Time]float64{}
+	for h := 0; h < 24; h++ {
+		if h == 2 {
+			continue
+		}
+		entries[time.Date(2025, 3, 30, h, 0, 0, 0, time.UTC)] = 1
+	}
+	entries[time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)] = 1
+	s := testSeries(t, config.IntervalHour, zurich, entries)
+
+	s.Normalize(config.IntervalDay)
+	if len(s.Buckets) != 2 {
+		t.Fatalf("Normalize() resulted in %d buckets, want 2", len(s.Buckets))
+	}
+	want := time.Date(2025, 3, 30, 0, 0, 0, 0, zurich)
+	if got := s.Buckets[0]; !got.Time.Equal(want) || got.Total.Consumption != 23 {
+		t.Errorf("Normalize() first bucket = (%s, %v Wh), want (%s, 23 Wh)", got.Time, got.Total.Consumption, want)
+	}
+	if got := s.Buckets[1].Time.Sub(s.Buckets[0].Time); got != 23*time.Hour {
+		t.Errorf("Normalize() resulted in a day of %s, want 23h", got)
+	}
+}
+
+func TestClip(t *testing.T) {
+	entries := map[time.Time]float64{}
+	for d := 1; d <= 5; d++ {
+		entries[day(d)] = float64(d)
+	}
+	s := testSeries(t, config.IntervalDay, time.UTC, entries)
+
+	// The bucket from falls into is kept, the one starting at to is not.
+	dropped := s.Clip(day(2).Add(12*time.Hour), day(4))
+	if len(s.Buckets) != 2 || !s.Buckets[0].Time.Equal(day(2)) || !s.Buckets[1].Time.Equal(day(3)) {
+		t.Errorf("Clip() kept %d buckets, want Jan 2 and 3", len(s.Buckets))
+	}
+	if len(dropped) != 3 {
+		t.Fatalf("Clip() dropped %d buckets, want 3", len(dropped))
+	}
+	for i, want := range []time.Time{day(1), day(4), day(5)} {
+		if !dropped[i].Time.Equal(want) {
+			t.Errorf("Clip() dropped bucket %d at %s, want %s", i, dropped[i].Time, want)
+		}
+	}
+}
+
+func TestDropBefore(t *testing.T) {
+	s := testSeries(t, config.IntervalDay, time.UTC, map[time.Time]float64{day(1): 1, day(2): 2, day(3): 3})
+	s.dropBefore(day(2))
+	if len(s.Buckets) != 2 || !s.Buckets[0].Time.Equal(day(2)) {
+		t.Errorf("dropBefore() kept %d buckets starting at %s, want 2 starting at %s", len(s.Buckets), s.Buckets[0].Time, day(2))
+	}
+}
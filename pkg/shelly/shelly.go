@@ -1,8 +1,12 @@
 package shelly
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"math"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,10 +17,36 @@ const (
 	DateTimeFmt = time.DateTime
 )
 
+// Source provides the power consumption statistics of devices, either from the Shelly cloud or
+// from the devices themselves.
+type Source interface {
+	PowerConsumption(ctx context.Context, dev *Device, channel int, interval string, from, to time.Time) (*PowerConsumptionStatistics, error)
+}
+
+// Device identifies the device to read the statistics of. Cloud devices are identified by their
+// ID, local devices are reached through their host.
+type Device struct {
+	ID       string
+	Name     string // only used in log messages
+	Type     string // name of a registered device type, e.g. "em-3p"
+	Timezone string // IANA timezone, overrides the one reported by the cloud or device
+
+	Host     string
+	Username string
+	Password string
+}
+
+// logf writes the message to the logger unless it is nil.
+func logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
+
 type PowerConsumptionStatistics struct {
-	DeviceType *config.DeviceType
-	Stats1p    *PowerConsumptionStatistics1p
-	Stats3p    *PowerConsumptionStatistics3p
+	DeviceType DeviceType
+	Channel    *config.Channel
+	Stats      *Series
 }
 
 type Entry struct {
@@ -30,6 +60,115 @@ type Entry struct {
 	Purpose     string     `json:"purpose"`
 	Cost        float64    `json:"cost"`
 	TariffID    string     `json:"tariff_id"`
+
+	// Set locally for buckets without any data and if their gap is filled.
+	IsAbsent    bool `json:"-"`
+	IsEstimated bool `json:"-"`
+	// Set locally if the entry is suspicious, e.g. "bounds" or "phase_a:z_score".
+	Anomalies []string `json:"-"`
+
+	// Computed locally if a tariff is configured.
+	Revenue      float64                 `json:"-"`
+	BaselineCost float64                 `json:"-"`
+	Periods      map[string]*PeriodUsage `json:"-"`
+}
+
+// PeriodUsage is the energy consumed during a tariff period and its cost.
+type PeriodUsage struct {
+	Consumption float64 // Wh
+	Cost        float64
+}
+
+// Value returns the value of the entry field with the given column name (see
+// config.SupportedColumns). Absent entries which were not estimated have no values.
+func (e *Entry) Value(field string) interface{} {
+	switch field {
+	case config.ColumnIsMissing:
+		return e.IsMissing
+	case config.ColumnIsEstimated:
+		return e.IsEstimated
+	case config.ColumnAnomalies:
+		return strings.Join(e.Anomalies, " ")
+	}
+	if e.IsAbsent && !e.IsEstimated {
+		return nil
+	}
+
+	switch field {
+	case config.ColumnConsumption:
+		return e.Consumption
+	case config.ColumnReturned:
+		return e.Reversed
+	case config.ColumnMinVoltage:
+		return e.MinVoltage
+	case config.ColumnMaxVoltage:
+		return e.MaxVoltage
+	case config.ColumnCost:
+		return e.Cost
+	case config.ColumnRevenue:
+		return e.Revenue
+	case config.ColumnEffectivePrice:
+		if e.Consumption == 0 {
+			return nil
+		}
+		return e.Cost / (e.Consumption / 1000)
+	case config.ColumnBaselineCost:
+		return e.BaselineCost
+	case config.ColumnSavings:
+		return e.BaselineCost - e.Cost
+	case config.ColumnTariffID:
+		return e.TariffID
+	case config.ColumnPurpose:
+		return e.Purpose
+	case config.ColumnChannel:
+		return e.Channel
+	}
+
+	if f, period, ok := strings.Cut(field, ":"); ok {
+		usage, ok := e.Periods[period]
+		if !ok {
+			usage = &PeriodUsage{}
+		}
+		switch f {
+		case config.ColumnPeriodConsumption:
+			return usage.Consumption
+		case config.ColumnPeriodCost:
+			return usage.Cost
+		}
+	}
+	return nil
+}
+
+// Column is an exported column of statistics.
+type Column struct {
+	Name  string // e.g. "phase_a_returned"
+	Field string // the entry field, e.g. config.ColumnReturned
+}
+
+// columnName returns the name of the column of the field for the given series (e.g. "total" or
+// "phase_a"). Consumption is named after the series itself and the other additive fields are
+// prefixed with it. Fields of the total which cannot be added up keep their plain name.
+func columnName(series, field string) string {
+	if f, period, ok := strings.Cut(field, ":"); ok {
+		// e.g. "peak_consumption" or "phase_a_peak_cost"
+		field = fmt.Sprintf("%s_%s", period, strings.TrimPrefix(f, "period_"))
+		if series == TotalSeries {
+			return field
+		}
+		return fmt.Sprintf("%s_%s", series, field)
+	}
+
+	switch field {
+	case config.ColumnConsumption:
+		return series
+	case config.ColumnReturned, config.ColumnCost, config.ColumnRevenue, config.ColumnBaselineCost, config.ColumnSavings:
+		return fmt.Sprintf("%s_%s", series, field)
+	default:
+		if series == TotalSeries {
+			return field
+		}
+		return fmt.Sprintf("%s_%s", series, field)
+	}
 }
 
 func combineEntries(entryA, entryB *Entry) *Entry {
@@ -47,18 +186,102 @@ func combineEntries(entryA, entryB *Entry) *Entry {
 	}
 
 	return &Entry{
-		DateTime:    entryA.DateTime,
-		Consumption: entryA.Consumption + entryB.Consumption,
-		Channel:     channel,
-		Reversed:    entryA.Reversed + entryB.Reversed,
-		MinVoltage:  math.Min(entryA.MinVoltage, entryB.MinVoltage),
-		MaxVoltage:  math.Max(entryA.MaxVoltage, entryB.MaxVoltage),
-		Cost:        entryA.Cost + entryB.Cost,
-		Purpose:     purpose,
-		TariffID:    tariff,
+		IsMissing:    entryA.IsMissing || entryB.IsMissing,
+		IsAbsent:     entryA.IsAbsent && entryB.IsAbsent,
+		IsEstimated:  entryA.IsEstimated || entryB.IsEstimated,
+		Anomalies:    append(slices.Clone(entryA.Anomalies), entryB.Anomalies...),
+		DateTime:     entryA.DateTime,
+		Consumption:  entryA.Consumption + entryB.Consumption,
+		Channel:      channel,
+		Reversed:     entryA.Reversed + entryB.Reversed,
+		MinVoltage:   math.Min(entryA.MinVoltage, entryB.MinVoltage),
+		MaxVoltage:   math.Max(entryA.MaxVoltage, entryB.MaxVoltage),
+		Cost:         entryA.Cost + entryB.Cost,
+		Purpose:      purpose,
+		TariffID:     tariff,
+		Revenue:      entryA.Revenue + entryB.Revenue,
+		BaselineCost: entryA.BaselineCost + entryB.BaselineCost,
+		Periods:      combinePeriods(entryA.Periods, entryB.Periods),
+	}
+}
+
+func combinePeriods(a, b map[string]*PeriodUsage) map[string]*PeriodUsage {
+	if a == nil && b == nil {
+		return nil
+	}
+	periods := map[string]*PeriodUsage{}
+	for _, m := range []map[string]*PeriodUsage{a, b} {
+		for name, usage := range m {
+			p, ok := periods[name]
+			if !ok {
+				p = &PeriodUsage{}
+				periods[name] = p
+			}
+			p.Consumption += usage.Consumption
+			p.Cost += usage.Cost
+		}
+	}
+	return periods
+}
+
+// intervals lists the supported intervals from the finest to the coarsest.
+var intervals = []string{config.IntervalHour, config.IntervalDay, config.IntervalMonth, config.IntervalYear}
+
+// isFinerOrEqual returns whether buckets of interval a can be rolled up into buckets of interval b.
+func isFinerOrEqual(a, b string) bool {
+	return slices.Index(intervals, a) >= 0 && slices.Index(intervals, a) <= slices.Index(intervals, b)
+}
+
+// bucketStart returns the start of the bucket of the given interval which t falls into.
+func bucketStart(interval string, t time.Time) time.Time {
+	switch interval {
+	case config.IntervalHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case config.IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case config.IntervalYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextBucket returns the start of the bucket following the one starting at t.
+func nextBucket(interval string, t time.Time) time.Time {
+	switch interval {
+	case config.IntervalHour:
+		return t.Add(time.Hour)
+	case config.IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	case config.IntervalYear:
+		return t.AddDate(1, 0, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// inLocation returns the wall clock time of t interpreted in loc.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// resolveLocation returns the location configured for the device if set, otherwise the one
+// reported by the Shelly cloud or the device itself, falling back to UTC.
+func resolveLocation(configured, reported string) (*time.Location, error) {
+	name := configured
+	if name == "" {
+		name = reported
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: %s", name, err)
 	}
+	return loc, nil
 }
 
+// ShellyTime is a date/time as reported by the Shelly API. The API reports wall clock times
+// without an offset which are parsed as UTC and need to be localized into the timezone of the
+// device.
 type ShellyTime time.Time
 
 func (s *ShellyTime) UnmarshalJSON(b []byte) error {
@@ -76,7 +299,7 @@ func (s ShellyTime) MarshalJSON() ([]byte, error) {
 }
 
 func (s ShellyTime) Format(ts string) string {
-	return time.Time(s).Format(DateTimeFmt)
+	return time.Time(s).Format(ts)
 }
 
 func (s ShellyTime) Before(t ShellyTime) bool {
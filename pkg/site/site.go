@@ -0,0 +1,128 @@
+// Package site analyses the self-consumption and autarky of a site from the statistics of its grid
+// and production meters.
+package site
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/export"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+var (
+	columns = []string{
+		"grid_import",
+		"grid_export",
+		"production",
+		"self_consumption",
+		"house_load",
+		"self_consumption_ratio",
+		"autarky",
+		"is_missing",
+	}
+	// energy marks the columns holding energy (Wh).
+	energy = []bool{true, true, true, true, true, false, false, false}
+)
+
+// bucket holds the energy of a site in one bucket (Wh).
+type bucket struct {
+	gridImport float64
+	gridExport float64
+	production float64
+	isMissing  bool
+}
+
+// Analyse computes per bucket how much of the production was consumed on site (production minus
+// the energy exported to the grid), the total load of the house (grid import plus
+// self-consumption), the self-consumption ratio (self-consumption per production) and the autarky
+// (self-consumption per house load). All channels of a device are summed up. Buckets for which a
+// meter has no data are marked as missing.
+func Analyse(site *config.Site, grid []*shelly.PowerConsumptionStatistics, production [][]*shelly.PowerConsumptionStatistics) (*export.Report, error) {
+	if len(grid) == 0 {
+		return nil, errors.New("no statistics for the grid meter")
+	}
+	interval := grid[0].Stats.Interval
+	loc := grid[0].Stats.Location()
+
+	buckets := map[time.Time]*bucket{}
+	get := func(ts time.Time) *bucket {
+		ts = ts.UTC()
+		b, ok := buckets[ts]
+		if !ok {
+			b = &bucket{}
+			buckets[ts] = b
+		}
+		return b
+	}
+
+	gridSeen := map[time.Time]bool{}
+	for _, stats := range grid {
+		for _, e := range stats.Stats.Totals() {
+			ts := time.Time(e.DateTime)
+			b := get(ts)
+			b.gridImport += e.Consumption
+			b.gridExport += e.Reversed
+			b.isMissing = b.isMissing || e.IsMissing
+			gridSeen[ts.UTC()] = true
+		}
+	}
+	prodSeen := make([]map[time.Time]bool, len(production))
+	for i, dev := range production {
+		prodSeen[i] = map[time.Time]bool{}
+		for _, stats := range dev {
+			if iv := stats.Stats.Interval; iv != interval {
+				return nil, errors.New("interval of the production meters is different from the one of the grid meter")
+			}
+			for _, e := range stats.Stats.Totals() {
+				ts := time.Time(e.DateTime)
+				b := get(ts)
+				if site.ProductionIsReturned {
+					b.production += e.Reversed
+				} else {
+					b.production += e.Consumption
+				}
+				b.isMissing = b.isMissing || e.IsMissing
+				prodSeen[i][ts.UTC()] = true
+			}
+		}
+	}
+
+	report := &export.Report{
+		Interval: interval,
+		Location: loc,
+		Columns:  columns,
+		Energy:   energy,
+		Rows:     map[time.Time][]interface{}{},
+	}
+	for ts, b := range buckets {
+		missing := b.isMissing || !gridSeen[ts]
+		for _, seen := range prodSeen {
+			missing = missing || !seen[ts]
+		}
+
+		selfConsumption := math.Max(b.production-b.gridExport, 0)
+		houseLoad := b.gridImport + selfConsumption
+		report.Rows[ts] = []interface{}{
+			b.gridImport,
+			b.gridExport,
+			b.production,
+			selfConsumption,
+			houseLoad,
+			ratio(selfConsumption, b.production),
+			ratio(selfConsumption, houseLoad),
+			missing,
+		}
+	}
+	return report, nil
+}
+
+// ratio returns a/b or nil if b is zero.
+func ratio(a, b float64) interface{} {
+	if b == 0 {
+		return nil
+	}
+	return a / b
+}
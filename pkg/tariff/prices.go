@@ -0,0 +1,149 @@
+package tariff
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// price is the price per kWh from its time on until the time of the next price.
+type price struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+// prices is a series of prices sorted by time, e.g. the hourly prices of a spot market.
+type prices []*price
+
+// readPrices reads the prices from a JSON or CSV file (by its extension). JSON files hold a list of
+// objects with a "time" and a "price", CSV files start with a header with the columns "time" and
+// "price". Times are RFC 3339 timestamps and prices are per kWh.
+func readPrices(file string) (prices, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open price file %q: %s", file, err)
+	}
+	defer f.Close()
+
+	var p prices
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&p); err != nil {
+			return nil, fmt.Errorf("unable to parse price file %q as JSON: %s", file, err)
+		}
+	case ".csv":
+		p, err = parsePricesCSV(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse price file %q as CSV: %s", file, err)
+		}
+	default:
+		return nil, fmt.Errorf("price file %q needs to be a .json or .csv file", file)
+	}
+
+	// The last price applies for as long as the one before it, which is unknown for a single price.
+	if len(p) < 2 {
+		return nil, fmt.Errorf("price file %q needs to contain at least two prices", file)
+	}
+	sort.Slice(p, func(i, j int) bool {
+		return p[i].Time.Before(p[j].Time)
+	})
+	return p, nil
+}
+
+func parsePricesCSV(r io.Reader) (prices, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %s", err)
+	}
+	timeIdx, priceIdx := -1, -1
+	for i, col := range header {
+		switch strings.ToLower(strings.TrimSpace(col)) {
+		case "time":
+			timeIdx = i
+		case "price":
+			priceIdx = i
+		}
+	}
+	if timeIdx < 0 || priceIdx < 0 {
+		return nil, errors.New(`header needs to have the columns "time" and "price"`)
+	}
+
+	p := prices{}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read row: %s", err)
+		}
+		ts, err := time.Parse(time.RFC3339, row[timeIdx])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse time %q: %s", row[timeIdx], err)
+		}
+		v, err := strconv.ParseFloat(row[priceIdx], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse price %q: %s", row[priceIdx], err)
+		}
+		p = append(p, &price{Time: ts, Price: v})
+	}
+	return p, nil
+}
+
+// index returns the index of the price in effect at the given time or -1 if there is none. There
+// is no price before the first one and after the last one plus the interval between the last two.
+func (p prices) index(ts time.Time) int {
+	i := sort.Search(len(p), func(i int) bool {
+		return p[i].Time.After(ts)
+	}) - 1
+	if i < 0 || !ts.Before(p.end(i)) {
+		return -1
+	}
+	return i
+}
+
+// end returns the time until which the i-th price applies.
+func (p prices) end(i int) time.Time {
+	switch {
+	case i < len(p)-1:
+		return p[i+1].Time
+	case len(p) < 2:
+		return p[i].Time
+	default:
+		return p[i].Time.Add(p[i].Time.Sub(p[i-1].Time))
+	}
+}
+
+// average returns the average of the prices from from to to, weighted by how long each of them
+// applies, e.g. of the four quarter-hourly prices of an hour. There is no average if the prices do
+// not cover the whole timeframe.
+func (p prices) average(from, to time.Time) (float64, bool) {
+	if !to.After(from) {
+		return 0, false
+	}
+	var sum float64
+	for ts := from; ts.Before(to); {
+		i := p.index(ts)
+		if i < 0 {
+			return 0, false
+		}
+		next := p.end(i)
+		if next.After(to) {
+			next = to
+		}
+		sum += p[i].Price * next.Sub(ts).Seconds()
+		ts = next
+	}
+	return sum / to.Sub(from).Seconds(), true
+}
@@ -0,0 +1,51 @@
+package tariff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAverage(t *testing.T) {
+	hour := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	quarters := prices{}
+	for i, v := range []float64{0.1, 0.2, 0.3, 0.4, 0.5} {
+		quarters = append(quarters, &price{Time: hour.Add(time.Duration(i) * 15 * time.Minute), Price: v})
+	}
+	hourly := prices{{Time: hour, Price: 0.2}, {Time: hour.Add(time.Hour), Price: 0.4}}
+
+	tests := []struct {
+		name     string
+		prices   prices
+		from, to time.Time
+		want     float64
+		wantOK   bool
+	}{
+		{name: "quarter hours", prices: quarters, from: hour, to: hour.Add(time.Hour), want: 0.25, wantOK: true},
+		{name: "last quarter hour extended", prices: quarters, from: hour.Add(time.Hour), to: hour.Add(75 * time.Minute), want: 0.5, wantOK: true},
+		{name: "beyond last quarter hour", prices: quarters, from: hour.Add(time.Hour), to: hour.Add(2 * time.Hour)},
+		{name: "hourly", prices: hourly, from: hour.Add(time.Hour), to: hour.Add(2 * time.Hour), want: 0.4, wantOK: true},
+		{name: "half covered", prices: hourly, from: hour.Add(-30 * time.Minute), to: hour.Add(30 * time.Minute)},
+		{name: "before first", prices: hourly, from: hour.Add(-time.Hour), to: hour},
+		{name: "no prices", prices: nil, from: hour, to: hour.Add(time.Hour)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tc.prices.average(tc.from, tc.to)
+			if ok != tc.wantOK || (ok && (got < tc.want-1e-9 || got > tc.want+1e-9)) {
+				t.Errorf("average() = (%v, %t), want (%v, %t)", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestReadPricesSingle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "prices.csv")
+	if err := os.WriteFile(file, []byte("time,price\n2024-03-01T00:00:00Z,0.2\n"), 0o644); err != nil {
+		t.Fatalf("unable to write price file: %s", err)
+	}
+	if _, err := readPrices(file); err == nil {
+		t.Errorf("readPrices() succeeded for a single price, want error")
+	}
+}
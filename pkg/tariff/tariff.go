@@ -0,0 +1,118 @@
+// Package tariff computes the cost of consumed and the revenue of returned energy locally based on
+// time-of-use or dynamic tariffs instead of relying on the cost reported by the Shelly cloud.
+package tariff
+
+import (
+	"log"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// Tariff prices statistics according to a configured tariff.
+type Tariff struct {
+	cfg    *config.Tariff
+	prices prices
+}
+
+// New returns the tariff for the config, reading its price file if there is one.
+func New(cfg *config.Tariff) (*Tariff, error) {
+	t := &Tariff{cfg: cfg}
+	if cfg.PriceFile != "" {
+		p, err := readPrices(cfg.PriceFile)
+		if err != nil {
+			return nil, err
+		}
+		t.prices = p
+	}
+	return t, nil
+}
+
+// Load returns the tariffs for all configs keyed by their ID.
+func Load(cfgs []*config.Tariff) (map[string]*Tariff, error) {
+	tariffs := map[string]*Tariff{}
+	for _, cfg := range cfgs {
+		t, err := New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		tariffs[cfg.ID] = t
+	}
+	return tariffs, nil
+}
+
+// Apply prices all entries of the statistics with the tariff. Each entry is priced as a whole at the
+// start of its bucket, or at the average spot price over its bucket, so the statistics should be
+// hourly and only rolled up afterwards. Entries without a price (before the first version of the
+// tariff and not covered by the price file) are left unpriced.
+func (t *Tariff) Apply(stats *shelly.Series) {
+	unpriced := 0
+	for _, series := range stats.Entries() {
+		for _, e := range series {
+			if !t.price(e, bucketEnd(stats.Interval, time.Time(e.DateTime))) {
+				unpriced++
+			}
+		}
+	}
+	if unpriced > 0 {
+		log.Printf("unable to price %d entries with tariff %q\n", unpriced, t.cfg.ID)
+	}
+}
+
+// bucketEnd returns the end of the bucket of the interval starting at t.
+func bucketEnd(interval string, t time.Time) time.Time {
+	switch interval {
+	case config.IntervalHour:
+		return t.Add(time.Hour)
+	case config.IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	case config.IntervalYear:
+		return t.AddDate(1, 0, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// price sets the cost, revenue and period of the entry ending at end and returns whether there was
+// a price.
+func (t *Tariff) price(e *shelly.Entry, end time.Time) bool {
+	ts := time.Time(e.DateTime)
+	version := t.cfg.Version(ts)
+
+	period := config.TariffBasePeriod
+	var price float64
+	if spot, ok := t.prices.average(ts, end); ok {
+		period = config.TariffSpotPeriod
+		price = spot
+	} else if version != nil {
+		price = version.Price
+		holiday := t.cfg.IsHoliday(ts)
+		for _, p := range version.Periods {
+			if p.Matches(ts, holiday) {
+				period = p.Name
+				price = p.Price
+				break
+			}
+		}
+	} else {
+		e.Cost = 0
+		e.Revenue = 0
+		e.BaselineCost = 0
+		e.TariffID = ""
+		e.Periods = nil
+		return false
+	}
+
+	e.Cost = e.Consumption / 1000 * price
+	e.Revenue = 0
+	if version != nil {
+		e.Revenue = e.Reversed / 1000 * version.FeedInPrice
+	}
+	e.BaselineCost = e.Consumption / 1000 * t.cfg.BaselinePrice
+	e.TariffID = period
+	e.Periods = map[string]*shelly.PeriodUsage{
+		period: {Consumption: e.Consumption, Cost: e.Cost},
+	}
+	return true
+}
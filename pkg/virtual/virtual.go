@@ -0,0 +1,102 @@
+// Package virtual computes the statistics of virtual devices from the statistics of the devices
+// they reference.
+package virtual
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+	"github.com/finfinack/shellyExport/pkg/shelly"
+)
+
+// Compute returns the statistics of the virtual device as the sum of its terms, each multiplied by
+// its factor. The statistics of the referenced devices are looked up by their name. The energy and
+// cost of each bucket are summed up, buckets for which a term has no data are marked as missing
+// and absent so that they are not exported as 0 Wh.
+func Compute(dev *config.Device, devType shelly.DeviceType, byName map[string][]*shelly.PowerConsumptionStatistics) (*shelly.PowerConsumptionStatistics, error) {
+	if devType.Phases() != 1 {
+		return nil, fmt.Errorf("device type %q of virtual device needs to be single phase", devType.Name())
+	}
+
+	var interval string
+	var loc *time.Location
+	sums := map[time.Time]*shelly.Entry{}
+	seen := make([]map[time.Time]bool, len(dev.Terms))
+	for i, term := range dev.Terms {
+		stats, ok := byName[term.Device]
+		if !ok {
+			return nil, fmt.Errorf("no statistics for device %q", term.Device)
+		}
+		seen[i] = map[time.Time]bool{}
+		found := false
+		for _, s := range stats {
+			if term.Channel != nil && s.Channel.Index != *term.Channel {
+				continue
+			}
+			found = true
+			if interval == "" {
+				interval = s.Stats.Interval
+				loc = s.Stats.Location()
+			}
+			if s.Stats.Interval != interval {
+				return nil, fmt.Errorf("interval of device %q (%q) is different from the others (%q)", term.Device, s.Stats.Interval, interval)
+			}
+
+			entries := s.Stats.Totals()
+			if idx := term.PhaseIndex(); idx >= 0 {
+				if idx >= len(s.Stats.Phases) {
+					return nil, fmt.Errorf("device %q has no phase %q", term.Device, term.Phase)
+				}
+				entries = s.Stats.PhaseEntries(idx)
+			}
+			for _, e := range entries {
+				ts := time.Time(e.DateTime).UTC()
+				sum, ok := sums[ts]
+				if !ok {
+					sum = &shelly.Entry{DateTime: e.DateTime}
+					sums[ts] = sum
+				}
+				sum.IsMissing = sum.IsMissing || e.IsMissing
+				sum.IsAbsent = sum.IsAbsent || (e.IsAbsent && !e.IsEstimated)
+				sum.IsEstimated = sum.IsEstimated || e.IsEstimated
+				sum.Consumption += term.Factor * e.Consumption
+				sum.Reversed += term.Factor * e.Reversed
+				sum.Cost += term.Factor * e.Cost
+				sum.Revenue += term.Factor * e.Revenue
+				sum.BaselineCost += term.Factor * e.BaselineCost
+				seen[i][ts] = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("device %q has no channel %d", term.Device, *term.Channel)
+		}
+	}
+
+	entries := []*shelly.Entry{}
+	for ts, sum := range sums {
+		for _, s := range seen {
+			sum.IsMissing = sum.IsMissing || !s[ts]
+			sum.IsAbsent = sum.IsAbsent || !s[ts]
+		}
+		// A sum without the data of one of its terms is unknown, even if other terms are estimated.
+		sum.IsEstimated = sum.IsEstimated && !sum.IsAbsent
+		entries = append(entries, sum)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DateTime.Before(entries[j].DateTime)
+	})
+	if loc == nil {
+		loc = time.UTC
+	}
+	stats, err := shelly.NewSeries(interval, loc, [][]*shelly.Entry{entries})
+	if err != nil {
+		return nil, err
+	}
+	return &shelly.PowerConsumptionStatistics{
+		DeviceType: devType,
+		Channel:    &config.Channel{Index: 0},
+		Stats:      stats,
+	}, nil
+}
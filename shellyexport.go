@@ -2,20 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
-	"net/url"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/finfinack/shellyExport/pkg/anomaly"
 	"github.com/finfinack/shellyExport/pkg/config"
 	"github.com/finfinack/shellyExport/pkg/export"
+	"github.com/finfinack/shellyExport/pkg/gaps"
 	"github.com/finfinack/shellyExport/pkg/shelly"
+	"github.com/finfinack/shellyExport/pkg/site"
+	"github.com/finfinack/shellyExport/pkg/tariff"
+	"github.com/finfinack/shellyExport/pkg/virtual"
 )
 
 var (
@@ -23,167 +27,326 @@ var (
 	outfilePfx = flag.String("out", "", "File path used as a prefix for where the output is written to.")
 )
 
-const (
-	baseURLPath = "/v2/statistics/power-consumption"
-	minInterval = 5 * 24 * time.Hour // 5 days
-)
-
-func pullStatisticsForTimeframe(cfg *config.Config, dev *config.Device, from, to time.Time) (*shelly.PowerConsumptionStatistics, error) {
-	url, err := url.Parse(cfg.Server)
-	if err != nil {
-		return nil, fmt.Errorf("invalid server specified in config (%q): %s\n", cfg.Server, err)
-	}
-	devType := config.SupportedDeviceTypes[strings.ToLower(dev.Type)]
-	url = url.JoinPath(url.Path, baseURLPath, devType.PathSuffix)
-	q := url.Query()
-	q.Set("id", dev.ID)
-	q.Set("channel", "0")
-	q.Set("date_range", "custom")
-	q.Set("date_from", from.Format(shelly.DateTimeFmt))
-	q.Set("date_to", to.Format(shelly.DateTimeFmt))
-	q.Set("auth_key", cfg.AuthKey)
-	url.RawQuery = q.Encode()
-
-	log.Printf("requesting stats for device %q (ID %s) from %q to %q\n", dev.Name, dev.ID, from.Format(shelly.DateTimeFmt), to.Format(shelly.DateTimeFmt))
-
-	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create request: %s\n", err)
+// newClient returns a client for the Shelly cloud as configured.
+func newClient(cfg *config.Config) (*shelly.Client, error) {
+	opts := []shelly.ClientOption{
+		shelly.WithTimeout(time.Duration(cfg.Timeout)),
+		shelly.WithRateLimit(cfg.RateLimit),
+		shelly.WithLogger(log.Default()),
+	}
+	if cfg.Retry != nil {
+		opts = append(opts, shelly.WithRetry(cfg.Retry.MaxRetries, time.Duration(cfg.Retry.InitialDelay), time.Duration(cfg.Retry.MaxDelay)))
 	}
 	if cfg.UserAgent != "" {
-		req.Header.Add("User-Agent", cfg.UserAgent)
+		opts = append(opts, shelly.WithUserAgent(cfg.UserAgent))
 	}
-
-	resp, err := http.DefaultClient.Do(req)
+	client, err := shelly.NewClient(cfg.Server, cfg.AuthKey, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("unable to fetch data: %s\n", err)
+		return nil, fmt.Errorf("unable to create Shelly client: %s", err)
 	}
-	if http.StatusOK != resp.StatusCode {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unable to fetch data (response code %d): %s", resp.StatusCode, b)
+	return client, nil
+}
+
+// applyDeviceTypes returns an error if a device uses a type which is not registered or a virtual
+// device uses a multi phase type and sets the default channels of the type for devices without
+// configured channels.
+func applyDeviceTypes(devices []*config.Device) error {
+	for i, dev := range devices {
+		devType, ok := shelly.LookupDeviceType(dev.Type)
+		if !ok {
+			names := []string{}
+			for _, devType := range shelly.DeviceTypes() {
+				names = append(names, devType.Name())
+			}
+			return fmt.Errorf("device type %q of device %d is not supported (supported: %s)", dev.Type, i, strings.Join(names, ", "))
+		}
+		// Virtual devices are computed after all other devices are exported, so fail early.
+		if dev.Source == config.SourceVirtual && devType.Phases() != 1 {
+			return fmt.Errorf("device type %q of virtual device %q needs to be single phase", dev.Type, dev.Name)
+		}
+		if len(dev.Channels) == 0 {
+			dev.Channels = devType.Channels()
+		}
 	}
+	return nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// output returns where the CSV export named after kind and name is written to: a file with the
+// given prefix if set, otherwise stdout unless there is no need for a CSV export (e.g. because it
+// is exported to a sheet instead), in which case nil is returned.
+func output(outpfx, kind, name string, toStdout bool) (io.WriteCloser, error) {
+	if outpfx == "" {
+		if !toStdout {
+			return nil, nil
+		}
+		return nopCloser{os.Stdout}, nil
+	}
+	name = strings.ReplaceAll(strings.ToLower(name), " ", "_")
+	outfile := fmt.Sprintf("%s-%s-%s.csv", outpfx, kind, name)
+	f, err := os.Create(outfile)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response body: %s\n", err)
+		return nil, fmt.Errorf("unable to open file %q for writing: %s", outfile, err)
 	}
+	log.Printf("writing output for %s %q to %q\n", kind, name, outfile)
+	return f, nil
+}
 
-	switch devType.Phases {
-	case 1:
-		stats := &shelly.PowerConsumptionStatistics1p{}
-		if err := json.Unmarshal(body, stats); err != nil {
-			return nil, fmt.Errorf("unable to parse body as JSON: %s", err)
-		}
-		if stats.Interval != "day" {
-			return nil, fmt.Errorf("returned interval is not supported: %q\n", stats.Interval)
-		}
-		stats.Normalize(from, to)
-		return &shelly.PowerConsumptionStatistics{DeviceType: devType, Stats1p: stats}, nil
-	case 3:
-		stats := &shelly.PowerConsumptionStatistics3p{}
-		if err := json.Unmarshal(body, stats); err != nil {
-			return nil, fmt.Errorf("unable to parse body as JSON: %s", err)
+// nopCloser keeps stdout open when the output is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// deviceName returns the name of the device used for its exports.
+func deviceName(dev *config.Device) string {
+	if dev.Name != "" {
+		return dev.Name
+	}
+	if dev.ID != "" {
+		return dev.ID
+	}
+	return dev.Host
+}
+
+// prepare marks the buckets of the timeframe the statistics of the device have no data for at all,
+// validates the statistics and estimates the missing buckets as configured. It returns an error if
+// the validation found severe anomalies.
+func prepare(dev *config.Device, stats []*shelly.PowerConsumptionStatistics, tf *config.Timeframe) error {
+	name := deviceName(dev)
+	severe := 0
+	for _, s := range stats {
+		s.Stats.Complete(time.Time(tf.From), time.Time(tf.To))
+
+		if dev.Validation != nil {
+			anomalies := anomaly.Check(s.Stats, dev.Validation)
+			counts := map[string]int{}
+			for _, a := range anomalies {
+				if counts[a.Label()] == 0 {
+					log.Printf("device %q (channel %s) has %s anomaly at %s (%.2f Wh)\n", name, s.Channel.Name(), a.Label(), a.Time.Format(time.RFC3339), a.Value)
+				}
+				counts[a.Label()]++
+				if a.Severe {
+					severe++
+				}
+			}
+			for _, label := range slices.Sorted(maps.Keys(counts)) {
+				if counts[label] > 1 {
+					log.Printf("device %q (channel %s) has %d %s anomalies in total\n", name, s.Channel.Name(), counts[label], label)
+				}
+			}
 		}
-		if stats.Interval != "day" {
-			return nil, fmt.Errorf("returned interval is not supported: %q\n", stats.Interval)
+
+		if filled := gaps.Fill(s.Stats, dev.Fill); filled > 0 {
+			log.Printf("estimated %d missing entries of device %q (channel %s) using %s fill\n", filled, name, s.Channel.Name(), dev.Fill)
 		}
-		stats.Normalize(from, to)
-		return &shelly.PowerConsumptionStatistics{DeviceType: devType, Stats3p: stats}, nil
-	default:
-		return nil, fmt.Errorf("unsupported amount of phases: %d", devType.Phases)
 	}
+	if severe > 0 {
+		return fmt.Errorf("device %q has %d severe anomalies", name, severe)
+	}
+	return nil
 }
 
-func pullStatistics(cfg *config.Config, dev *config.Device) (*shelly.PowerConsumptionStatistics, error) {
-	var stats *shelly.PowerConsumptionStatistics
-
-	from := time.Time(cfg.Timeframe.From)
-	to := time.Time(from).AddDate(0, 1, 0)
-	for {
-		if !to.Before(time.Time(cfg.Timeframe.To)) {
-			to = time.Time(cfg.Timeframe.To)
+// reportGaps logs the number of missing buckets of each phase of the device and writes its gaps
+// next to the exports if they are written to files.
+func reportGaps(dev *config.Device, stats []*shelly.PowerConsumptionStatistics, outpfx string) error {
+	name := deviceName(dev)
+	gs := []*gaps.Gap{}
+	for _, s := range stats {
+		channelGaps := gaps.Find(s)
+		missing := map[string]int{}
+		for _, g := range channelGaps {
+			missing[g.Series] += g.Buckets
 		}
-		// In some cases the above may lead to the interval being switched to "hour" instead
-		// of "day" and we do not support that (yet). Instead we rather risk getting data
-		// for future dates.
-		if to.Sub(from) < minInterval {
-			to = from.Add(minInterval)
+		for _, series := range s.Stats.SeriesNames() {
+			if missing[series] > 0 {
+				log.Printf("device %q (channel %s) is missing %d of %d buckets for %s\n", name, s.Channel.Name(), missing[series], len(s.Stats.Buckets), series)
+			}
 		}
+		gs = append(gs, channelGaps...)
+	}
+	if len(gs) == 0 {
+		return nil
+	}
 
-		statsFrame, err := pullStatisticsForTimeframe(cfg, dev, from, to)
-		if err != nil {
-			return nil, fmt.Errorf("unable to pull statistics from %q to %q: %s", from, to, err)
+	out, err := output(outpfx, "gaps", name, false)
+	if err != nil || out == nil {
+		return err
+	}
+	defer out.Close()
+	if err := export.GapsToCSV(gs, stats[0].Stats.Interval, out); err != nil {
+		return fmt.Errorf("unable to export gaps to CSV: %s", err)
+	}
+	return nil
+}
+
+// exportDevice writes the statistics of the device to CSV and its sheet as configured.
+func exportDevice(ctx context.Context, dev *config.Device, stats []*shelly.PowerConsumptionStatistics, outpfx string) error {
+	name := deviceName(dev)
+	out, err := output(outpfx, "dev", name, dev.GoogleSheet == nil)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		defer out.Close()
+		if err := export.ToCSV(stats, dev.Columns, dev.Format, out); err != nil {
+			return fmt.Errorf("unable to export to CSV: %s", err)
 		}
+	}
 
-		switch statsFrame.DeviceType.Phases {
-		case 1:
-			if stats == nil {
-				stats = statsFrame
-			} else {
-				stats.Stats1p.Add(statsFrame.Stats1p)
-			}
-		case 3:
-			if stats == nil {
-				stats = statsFrame
-			} else {
-				stats.Stats3p.Add(statsFrame.Stats3p)
-			}
-		default:
-			return nil, fmt.Errorf("unsupported amount of phases: %d", statsFrame.DeviceType.Phases)
+	if dev.GoogleSheet != nil {
+		if err := export.ToGoogleSheet(ctx, stats, dev.Columns, dev.GoogleSheet); err != nil {
+			return fmt.Errorf("unable to export to sheet: %s", err)
 		}
+	}
 
-		if !to.Before(time.Time(cfg.Timeframe.To)) {
-			break
+	for _, r := range dev.Rollups {
+		if err := exportRollup(ctx, dev, name, r, stats, outpfx); err != nil {
+			return fmt.Errorf("unable to export %s rollup: %s", r.Name(), err)
 		}
+	}
+	return nil
+}
 
-		from = to
-		to = time.Time(from).AddDate(0, 1, 0)
+func exportRollup(ctx context.Context, dev *config.Device, name string, r *config.Rollup, stats []*shelly.PowerConsumptionStatistics, outpfx string) error {
+	out, err := output(outpfx, "dev", fmt.Sprintf("%s-%s", name, r.Name()), dev.GoogleSheet == nil)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		defer out.Close()
+		if err := export.RollupToCSV(stats, dev.Columns, r, dev.Format, out); err != nil {
+			return fmt.Errorf("unable to export to CSV: %s", err)
+		}
 	}
 
-	return stats, nil
+	if dev.GoogleSheet != nil {
+		if err := export.RollupToGoogleSheet(ctx, stats, dev.Columns, r, dev.GoogleSheet); err != nil {
+			return fmt.Errorf("unable to export to sheet: %s", err)
+		}
+	}
+	return nil
 }
 
 func run(ctx context.Context, cfg *config.Config, outpfx string) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	devices := []*config.Device{}
+	virtuals := []*config.Device{}
 	for _, dev := range cfg.Devices {
 		if dev.IsDisabled {
 			log.Printf("skipping device %s (ID %s) because it is disabled\n", dev.Name, dev.ID)
 			continue
 		}
+		if dev.Source == config.SourceVirtual {
+			virtuals = append(virtuals, dev)
+			continue
+		}
+		devices = append(devices, dev)
+	}
+	if err := applyDeviceTypes(append(devices, virtuals...)); err != nil {
+		return err
+	}
 
-		stats, err := pullStatistics(cfg, dev)
-		if err != nil {
-			return fmt.Errorf("unable to pull statistics: %s", err)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sources := map[string]shelly.Source{
+		config.SourceCloud: client,
+		config.SourceGen1:  shelly.NewGen1Client(nil, time.Duration(cfg.Timeout), log.Default()),
+		config.SourceGen2:  shelly.NewGen2Client(nil, time.Duration(cfg.Timeout), log.Default()),
+	}
+	tariffs, err := tariff.Load(cfg.Tariffs)
+	if err != nil {
+		return fmt.Errorf("unable to load tariffs: %s", err)
+	}
+	pool := newFetcher(sources, tariffs, cfg)
+	results := pool.pullAll(ctx, devices)
+
+	// Devices are fetched concurrently but exported in the order of the config as soon as
+	// they are available.
+	byName := map[string][]*shelly.PowerConsumptionStatistics{}
+	for i, dev := range devices {
+		res := <-results[i]
+		if res.err != nil {
+			return fmt.Errorf("unable to pull statistics: %s", res.err)
+		}
+		if dev.Name != "" {
+			byName[dev.Name] = res.stats
+		}
+		if err := prepare(dev, res.stats, cfg.Timeframe); err != nil {
+			return err
+		}
+		if err := reportGaps(dev, res.stats, outpfx); err != nil {
+			return err
+		}
+		if err := exportDevice(ctx, dev, res.stats, outpfx); err != nil {
+			return err
 		}
+	}
 
-		var out io.Writer
-		if dev.GoogleSheet == nil {
-			out = os.Stdout
+	// Virtual devices are computed once the statistics of all devices are available. They can
+	// reference virtual devices defined before them.
+	for _, dev := range virtuals {
+		devType, _ := shelly.LookupDeviceType(dev.Type)
+		stats, err := virtual.Compute(dev, devType, byName)
+		if err != nil {
+			return fmt.Errorf("unable to compute virtual device %q: %s", dev.Name, err)
 		}
-		if outpfx != "" {
-			name := dev.Name
-			if name == "" {
-				name = dev.ID
-			}
-			name = strings.ReplaceAll(strings.ToLower(name), " ", "_")
-			outfile := fmt.Sprintf("%s-dev-%s.csv", outpfx, name)
-			out, err = os.Create(outfile)
-			if err != nil {
-				return fmt.Errorf("unable to open file %q for writing: %s", outfile, err)
-			} else {
-				log.Printf("writing output for device %q (ID %q) to %q\n", dev.Name, dev.ID, outfile)
-			}
+		byName[dev.Name] = []*shelly.PowerConsumptionStatistics{stats}
+		if err := prepare(dev, byName[dev.Name], cfg.Timeframe); err != nil {
+			return err
 		}
-		if out != nil {
-			export.ToCSV(stats, out)
+		if err := exportDevice(ctx, dev, byName[dev.Name], outpfx); err != nil {
+			return err
 		}
+	}
 
-		if dev.GoogleSheet != nil {
-			if err := export.ToGoogleSheet(ctx, stats, dev.GoogleSheet); err != nil {
-				return fmt.Errorf("unable to export to sheet: %s", err)
-			}
+	// Sites are analysed once the statistics of all devices are available.
+	for _, s := range cfg.Sites {
+		if err := exportSite(ctx, s, byName, outpfx); err != nil {
+			return fmt.Errorf("unable to export site %q: %s", s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// exportSite analyses the site from the statistics of its devices and writes the report to CSV and
+// its sheet as configured.
+func exportSite(ctx context.Context, s *config.Site, byName map[string][]*shelly.PowerConsumptionStatistics, outpfx string) error {
+	production := [][]*shelly.PowerConsumptionStatistics{}
+	for _, name := range s.Production {
+		production = append(production, byName[name])
+	}
+	report, err := site.Analyse(s, byName[s.Grid], production)
+	if err != nil {
+		return fmt.Errorf("unable to analyse: %s", err)
+	}
+
+	out, err := output(outpfx, "site", s.Name, s.GoogleSheet == nil)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		if err := export.ReportToCSV(report, s.Format, out); err != nil {
+			out.Close()
+			return fmt.Errorf("unable to export to CSV: %s", err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("unable to close CSV: %s", err)
 		}
 	}
 
+	if s.GoogleSheet != nil {
+		if err := export.ReportToGoogleSheet(ctx, report, s.GoogleSheet); err != nil {
+			return fmt.Errorf("unable to export to sheet: %s", err)
+		}
+	}
 	return nil
 }
 
@@ -191,6 +354,13 @@ func main() {
 	flag.Parse()
 	ctx := context.Background()
 
+	if flag.Arg(0) == "discover" {
+		if err := discoverCmd(ctx, *configFile, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	cfg, err := config.ReadFromFile(*configFile)
 	if err != nil {
 		log.Fatalf("unable to read config: %s", err)
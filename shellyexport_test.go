@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/finfinack/shellyExport/pkg/config"
+)
+
+func TestApplyDeviceTypesVirtual(t *testing.T) {
+	tests := []struct {
+		devType string
+		wantErr bool
+	}{
+		{devType: "em-1"},
+		{devType: "em-3p", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.devType, func(t *testing.T) {
+			dev := &config.Device{Name: "virtual", Type: tc.devType, Source: config.SourceVirtual}
+			err := applyDeviceTypes([]*config.Device{dev})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("applyDeviceTypes() returned error %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}